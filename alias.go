@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseAliases parses -alias definitions of the form name=package. A
+// definition can name several packages separated by commas.
+func parseAliases(definitions []string) (map[string][]string, error) {
+	aliases := make(map[string][]string)
+	for _, definition := range definitions {
+		name, packages, ok := strings.Cut(definition, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || len(commaList(packages)) == 0 {
+			return nil, fmt.Errorf("alias %q is not of the form name=package", definition)
+		}
+		aliases[name] = commaList(packages)
+	}
+	return aliases, nil
+}
+
+// expandAliases replaces every @name in packages with the packages that
+// alias name stands for.
+func expandAliases(packages []string, aliases map[string][]string) ([]string, error) {
+	var expanded []string
+	for _, pkg := range packages {
+		if !strings.HasPrefix(pkg, "@") {
+			expanded = append(expanded, pkg)
+			continue
+		}
+		alias, ok := aliases[pkg[1:]]
+		if !ok {
+			return nil, fmt.Errorf("unknown package alias %q", pkg)
+		}
+		expanded = append(expanded, alias...)
+	}
+	return expanded, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAliases(t *testing.T) {
+	got, err := parseAliases([]string{"core=state,api", " agents = worker/uniter , cmd/jujud", "core=state"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"core":   {"state"},
+		"agents": {"worker/uniter", "cmd/jujud"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseAliases() = %q, want %q", got, want)
+	}
+
+	for _, bad := range []string{"core", "=state", "core=", "core= , "} {
+		if _, err := parseAliases([]string{bad}); err == nil {
+			t.Errorf("parseAliases(%q) didn't fail", bad)
+		}
+	}
+}
+
+func TestExpandAliases(t *testing.T) {
+	aliases := map[string][]string{
+		"core":   {"state", "api"},
+		"agents": {"worker/uniter"},
+	}
+	tests := []struct {
+		name     string
+		packages []string
+		want     []string
+		err      string
+	}{
+		{"no aliases", []string{"state", "cmd"}, []string{"state", "cmd"}, ""},
+		{"alias", []string{"@core"}, []string{"state", "api"}, ""},
+		{"mixed, in place", []string{"cmd", "@agents", "@core", "rpc"},
+			[]string{"cmd", "worker/uniter", "state", "api", "rpc"}, ""},
+		{"unknown", []string{"state", "@nope"}, nil, `unknown package alias "@nope"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := expandAliases(test.packages, aliases)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("expandAliases() error = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expandAliases() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// benchmark is one line of go test -bench output.
+type benchmark struct {
+	Package     string
+	Name        string
+	Iterations  int64
+	NsPerOp     float64
+	BytesPerOp  float64
+	AllocsPerOp float64
+}
+
+// parseBenchmarks reads the benchmark lines from go test -bench output. Each
+// benchmark is attributed to the package named by the "pkg:" line before it,
+// or to pkg if there isn't one.
+func parseBenchmarks(pkg string, output io.Reader) []benchmark {
+	var benchmarks []benchmark
+	current := pkg
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "pkg: ") {
+			current = strings.TrimPrefix(line, "pkg: ")
+			continue
+		}
+		if b, ok := parseBenchmarkLine(line); ok {
+			b.Package = current
+			benchmarks = append(benchmarks, b)
+		}
+	}
+	return benchmarks
+}
+
+// parseBenchmarkLine parses a line such as
+//
+//	BenchmarkFoo-8   1000000   1234 ns/op   256 B/op   3 allocs/op
+func parseBenchmarkLine(line string) (benchmark, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") {
+		return benchmark{}, false
+	}
+	b := benchmark{Name: fields[0]}
+	var err error
+	if b.Iterations, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+		return benchmark{}, false
+	}
+	for i := 2; i+1 < len(fields); i += 2 {
+		value, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return benchmark{}, false
+		}
+		switch fields[i+1] {
+		case "ns/op":
+			b.NsPerOp = value
+		case "B/op":
+			b.BytesPerOp = value
+		case "allocs/op":
+			b.AllocsPerOp = value
+		}
+	}
+	return b, true
+}
+
+// benchmarkKey identifies a benchmark across runs.
+func benchmarkKey(b benchmark) string {
+	return b.Package + "." + b.Name
+}
+
+// averageBenchmarks combines repeated runs of the same benchmark into one
+// with the mean of each measurement, sorted by package and name.
+func averageBenchmarks(benchmarks []benchmark) []benchmark {
+	sums := make(map[string]*benchmark)
+	counts := make(map[string]float64)
+	var keys []string
+	for _, b := range benchmarks {
+		key := benchmarkKey(b)
+		sum, ok := sums[key]
+		if !ok {
+			sum = &benchmark{Package: b.Package, Name: b.Name}
+			sums[key] = sum
+			keys = append(keys, key)
+		}
+		sum.Iterations += b.Iterations
+		sum.NsPerOp += b.NsPerOp
+		sum.BytesPerOp += b.BytesPerOp
+		sum.AllocsPerOp += b.AllocsPerOp
+		counts[key]++
+	}
+	sort.Strings(keys)
+
+	var averages []benchmark
+	for _, key := range keys {
+		b, n := *sums[key], counts[key]
+		b.Iterations = int64(float64(b.Iterations) / n)
+		b.NsPerOp /= n
+		b.BytesPerOp /= n
+		b.AllocsPerOp /= n
+		averages = append(averages, b)
+	}
+	return averages
+}
+
+// writeBenchReport writes a table of benchmarks to w. If baseline holds any
+// benchmarks, each one found there is compared with its baseline ns/op.
+func writeBenchReport(w io.Writer, benchmarks, baseline []benchmark) {
+	old := make(map[string]benchmark)
+	for _, b := range averageBenchmarks(baseline) {
+		old[benchmarkKey(b)] = b
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	if len(old) > 0 {
+		fmt.Fprintln(tw, "package\tbenchmark\tns/op\tB/op\tallocs/op\tbaseline ns/op\tdelta")
+	} else {
+		fmt.Fprintln(tw, "package\tbenchmark\tns/op\tB/op\tallocs/op")
+	}
+	for _, b := range averageBenchmarks(benchmarks) {
+		fmt.Fprintf(tw, "%s\t%s\t%.2f\t%.0f\t%.0f", b.Package, b.Name, b.NsPerOp, b.BytesPerOp, b.AllocsPerOp)
+		if len(old) > 0 {
+			if base, ok := old[benchmarkKey(b)]; ok && base.NsPerOp > 0 {
+				delta := (b.NsPerOp - base.NsPerOp) / base.NsPerOp * 100
+				fmt.Fprintf(tw, "\t%.2f\t%+.2f%%", base.NsPerOp, delta)
+			} else {
+				fmt.Fprint(tw, "\t-\t-")
+			}
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+}
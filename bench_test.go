@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const sampleBenchOutput = `goos: linux
+goarch: amd64
+pkg: github.com/juju/juju/state
+cpu: Intel(R) Xeon(R) CPU @ 2.20GHz
+BenchmarkAddUnit-8         	    1000	   1234567 ns/op	   25600 B/op	     312 allocs/op
+BenchmarkWatcher-8         	  500000	      2345 ns/op
+PASS
+ok  	github.com/juju/juju/state	5.123s
+pkg: github.com/juju/juju/api
+BenchmarkLogin-8   	   20000	     61234.5 ns/op	    4096 B/op	      51 allocs/op
+Benchmark line that isn't a result
+--- BENCH: BenchmarkLogin-8
+PASS
+`
+
+func TestParseBenchmarks(t *testing.T) {
+	got := parseBenchmarks("state", strings.NewReader(sampleBenchOutput))
+	want := []benchmark{
+		{Package: "github.com/juju/juju/state", Name: "BenchmarkAddUnit-8", Iterations: 1000,
+			NsPerOp: 1234567, BytesPerOp: 25600, AllocsPerOp: 312},
+		{Package: "github.com/juju/juju/state", Name: "BenchmarkWatcher-8", Iterations: 500000,
+			NsPerOp: 2345},
+		{Package: "github.com/juju/juju/api", Name: "BenchmarkLogin-8", Iterations: 20000,
+			NsPerOp: 61234.5, BytesPerOp: 4096, AllocsPerOp: 51},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseBenchmarks() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestParseBenchmarksWithoutPkgLine(t *testing.T) {
+	got := parseBenchmarks("state", strings.NewReader("BenchmarkAddUnit-8 1000 1234 ns/op\n"))
+	if len(got) != 1 || got[0].Package != "state" {
+		t.Errorf("parseBenchmarks() = %+v, want one benchmark in state", got)
+	}
+}
+
+func TestWriteBenchReport(t *testing.T) {
+	benchmarks := []benchmark{
+		{Package: "state", Name: "BenchmarkAddUnit-8", Iterations: 1000, NsPerOp: 1100, BytesPerOp: 256, AllocsPerOp: 3},
+		{Package: "state", Name: "BenchmarkAddUnit-8", Iterations: 3000, NsPerOp: 1300, BytesPerOp: 256, AllocsPerOp: 3},
+		{Package: "api", Name: "BenchmarkLogin-8", Iterations: 100, NsPerOp: 500},
+	}
+	baseline := []benchmark{
+		{Package: "state", Name: "BenchmarkAddUnit-8", Iterations: 1000, NsPerOp: 1000},
+	}
+	tests := []struct {
+		name     string
+		baseline []benchmark
+		want     string
+	}{{
+		name: "no baseline",
+		want: `package  benchmark           ns/op    B/op  allocs/op
+api      BenchmarkLogin-8    500.00   0     0
+state    BenchmarkAddUnit-8  1200.00  256   3
+`,
+	}, {
+		name:     "baseline",
+		baseline: baseline,
+		want: `package  benchmark           ns/op    B/op  allocs/op  baseline ns/op  delta
+api      BenchmarkLogin-8    500.00   0     0          -               -
+state    BenchmarkAddUnit-8  1200.00  256   3          1000.00         +20.00%
+`,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			writeBenchReport(&out, benchmarks, test.baseline)
+			if out.String() != test.want {
+				t.Errorf("writeBenchReport() =\n%s\nwant\n%s", out.String(), test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// listedTest matches the names of tests printed by go test -list.
+var listedTest = regexp.MustCompile(`(?m)^(Test\w*)\r?$`)
+
+// listTests returns the sorted, distinct test names in the output of
+// go test -list.
+func listTests(output string) []string {
+	seen := make(map[string]bool)
+	var tests []string
+	for _, match := range listedTest.FindAllStringSubmatch(output, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			tests = append(tests, match[1])
+		}
+	}
+	sort.Strings(tests)
+	return tests
+}
+
+// runPattern is a -run pattern matching exactly the tests given.
+func runPattern(tests []string) string {
+	return "^(" + strings.Join(tests, "|") + ")$"
+}
+
+// bisectTests narrows tests down to the one that fails. fails runs a set of
+// tests and reports whether any of them failed. Each round keeps whichever
+// half fails; if neither does the failure wasn't reproduced and there's no
+// culprit.
+func bisectTests(tests []string, fails func(tests []string) (bool, error)) (string, error) {
+	for len(tests) > 1 {
+		half := len(tests) / 2
+		failed, err := fails(tests[:half])
+		if err != nil {
+			return "", err
+		}
+		if failed {
+			tests = tests[:half]
+			continue
+		}
+		failed, err = fails(tests[half:])
+		if err != nil {
+			return "", err
+		}
+		if !failed {
+			return "", fmt.Errorf("%s didn't fail again", runPattern(tests))
+		}
+		tests = tests[half:]
+	}
+	if len(tests) == 0 {
+		return "", fmt.Errorf("no tests to bisect")
+	}
+	return tests[0], nil
+}
+
+// bisectFlaky reruns the tests of pkg, which has just failed in the current
+// directory, RemoteWorker.bisectCount times at a go to find the test that
+// is failing.
+func (r *RemoteWorker) bisectFlaky(pkg string) (string, error) {
+	timeout, ok := r.timeouts[pkg]
+	if !ok {
+		timeout = defaultTestTimeout
+	}
+	output, status := r.runCommand("go test -list . ./...")
+	if status != 0 {
+		return "", fmt.Errorf("unable to list tests: exit status %d", status)
+	}
+	log.Printf("bisecting %s on %s", pkg, r.host)
+	return bisectTests(listTests(output), func(tests []string) (bool, error) {
+		output, status := r.runCommand(fmt.Sprintf("go test -test.timeout=%s -count=%d -run='%s' ./...",
+			timeout, r.bisectCount, runPattern(tests)))
+		if status < 0 || r.cancelled() {
+			return false, fmt.Errorf("interrupted")
+		}
+		return status != 0 || !passed(output), nil
+	})
+}
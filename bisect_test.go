@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestListTests(t *testing.T) {
+	output := "TestWatch\r\nTestAddUnit\nExampleNewState\nBenchmarkAdd\nTestAddUnit\nok  \tgithub.com/juju/juju/state\t0.012s\n"
+	if got, want := listTests(output), []string{"TestAddUnit", "TestWatch"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listTests() = %q, want %q", got, want)
+	}
+}
+
+func TestRunPattern(t *testing.T) {
+	if got, want := runPattern([]string{"TestAddUnit", "TestWatch"}), "^(TestAddUnit|TestWatch)$"; got != want {
+		t.Errorf("runPattern() = %q, want %q", got, want)
+	}
+}
+
+func TestBisectTests(t *testing.T) {
+	tests := []string{"TestA", "TestB", "TestC", "TestD", "TestE"}
+	for _, culprit := range tests {
+		var rounds int
+		got, err := bisectTests(tests, func(run []string) (bool, error) {
+			rounds++
+			for _, test := range run {
+				if test == culprit {
+					return true, nil
+				}
+			}
+			return false, nil
+		})
+		if err != nil || got != culprit {
+			t.Errorf("bisectTests() = %q, %v, want %s", got, err, culprit)
+		}
+		if rounds > 6 {
+			t.Errorf("found %s in %d runs", culprit, rounds)
+		}
+	}
+}
+
+func TestBisectTestsErrors(t *testing.T) {
+	never := func([]string) (bool, error) { return false, nil }
+	tests := []struct {
+		name  string
+		tests []string
+		fails func([]string) (bool, error)
+		err   string
+	}{
+		{"not reproduced", []string{"TestA", "TestB"}, never, "^(TestA|TestB)$ didn't fail again"},
+		{"no tests", nil, never, "no tests to bisect"},
+		{"run fails", []string{"TestA", "TestB"}, func([]string) (bool, error) { return false, errors.New("interrupted") }, "interrupted"},
+	}
+	for _, test := range tests {
+		if _, err := bisectTests(test.tests, test.fails); err == nil || err.Error() != test.err {
+			t.Errorf("%s: bisectTests() error = %v, want %q", test.name, err, test.err)
+		}
+	}
+	// A single test is the culprit without being run again.
+	if got, err := bisectTests([]string{"TestA"}, nil); got != "TestA" || err != nil {
+		t.Errorf("bisectTests() of one test = %q, %v", got, err)
+	}
+}
+
+func TestBisectFlaky(t *testing.T) {
+	run := regexp.MustCompile(`-run='\^\(([^)]*)\)\$'`)
+	shell := newFakeShell(func(command string) (string, int) {
+		if strings.HasPrefix(command, "go test -list") {
+			return "TestWatch\nTestAddUnit\nTestLogin\nok  \tgithub.com/juju/juju/state\t0.012s\n", 0
+		}
+		if matched := run.FindStringSubmatch(command); matched != nil {
+			if strings.Contains(matched[1], "TestLogin") {
+				return "--- FAIL: TestLogin (0.01s)\nFAIL\n", 1
+			}
+			return "ok  \tgithub.com/juju/juju/state\t0.012s\n", 0
+		}
+		return "", 127
+	})
+	r := newFakeWorker(t, shell)
+	r.bisectCount = 5
+	got, err := r.bisectFlaky("state")
+	if err != nil || got != "TestLogin" {
+		t.Fatalf("bisectFlaky() = %q, %v, want TestLogin", got, err)
+	}
+	if commands := shell.Commands(); len(commands) < 2 || commands[1] != "go test -test.timeout=20m0s -count=5 -run='^(TestAddUnit)$' ./..." {
+		t.Errorf("ran %q", commands)
+	}
+}
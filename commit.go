@@ -0,0 +1,23 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// gitOutput runs git with args in the current directory and returns what it
+// writes to stdout.
+var gitOutput = func(args ...string) ([]byte, error) {
+	return exec.Command("git", args...).Output()
+}
+
+// localCommit returns the commit checked out in the current directory, so
+// results can be tied to the code they were run against. It returns "" if
+// the current directory isn't in a git repository or git isn't installed.
+func localCommit() string {
+	out, err := gitOutput("rev-parse", "HEAD")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestLocalCommit(t *testing.T) {
+	defer func(original func(...string) ([]byte, error)) { gitOutput = original }(gitOutput)
+	tests := []struct {
+		name   string
+		output string
+		err    error
+		want   string
+	}{
+		{"checked out", "0123abcd4567ef890123abcd4567ef890123abcd\n", nil, "0123abcd4567ef890123abcd4567ef890123abcd"},
+		{"not a repository", "", errors.New("exit status 128"), ""},
+		{"no git", "", errors.New(`exec: "git": executable file not found in $PATH`), ""},
+	}
+	for _, test := range tests {
+		var ran []string
+		gitOutput = func(args ...string) ([]byte, error) {
+			ran = args
+			return []byte(test.output), test.err
+		}
+		if got := localCommit(); got != test.want {
+			t.Errorf("%s: localCommit() = %q, want %q", test.name, got, test.want)
+		}
+		if want := []string{"rev-parse", "HEAD"}; !reflect.DeepEqual(ran, want) {
+			t.Errorf("%s: ran git %q, want %q", test.name, ran, want)
+		}
+	}
+}
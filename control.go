@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+)
+
+// controlRequest is a command sent to the control socket. Each is a JSON
+// object on its own line, answered by a controlResponse.
+type controlRequest struct {
+	Command string `json:"command"`
+	Worker  string `json:"worker,omitempty"`
+	Package string `json:"package,omitempty"`
+}
+
+// controlResponse is the answer to a controlRequest. Error is empty if the
+// command succeeded. Status is only set by the status command.
+type controlResponse struct {
+	Error  string      `json:"error,omitempty"`
+	Status *farmStatus `json:"status,omitempty"`
+}
+
+// serveControl accepts connections on l and runs the commands sent over them
+// against f until l is closed.
+func serveControl(l net.Listener, f *farm) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go handleControl(conn, f)
+	}
+}
+
+// handleControl runs each command sent over conn in turn.
+func handleControl(conn net.Conn, f *farm) {
+	defer conn.Close()
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+	for {
+		var req controlRequest
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		resp, err := runControl(req, f)
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// runControl runs a single control command.
+func runControl(req controlRequest, f *farm) (controlResponse, error) {
+	var resp controlResponse
+	switch req.Command {
+	case "status":
+		status := f.Status()
+		resp.Status = &status
+		return resp, nil
+	case "pause-dispatch":
+		f.dispatch.Pause()
+		log.Print("paused dispatch")
+		return resp, nil
+	case "resume":
+		f.dispatch.Resume()
+		log.Print("resumed dispatch")
+		return resp, nil
+	case "drain":
+		f.StopDispatch()
+		log.Print("draining: waiting for packages in progress")
+		return resp, nil
+	case "add-worker":
+		if req.Worker == "" {
+			return resp, fmt.Errorf("add-worker needs a worker")
+		}
+		if err := f.AddWorker(req.Worker); err != nil {
+			return resp, err
+		}
+		log.Printf("added worker %s", req.Worker)
+		return resp, nil
+	case "cancel-package":
+		if req.Package == "" {
+			return resp, fmt.Errorf("cancel-package needs a package")
+		}
+		f.CancelPackage(req.Package)
+		log.Printf("cancelled %s", req.Package)
+		return resp, nil
+	}
+	return resp, fmt.Errorf("unknown command %q", req.Command)
+}
+
+// runControlClient is the control subcommand. It sends one command to the
+// control socket of a running farm and prints the response:
+//
+//	test_farm control -control /tmp/farm.sock status
+//	test_farm control -control /tmp/farm.sock add-worker homework3
+//	test_farm control -control /tmp/farm.sock cancel-package state
+func runControlClient(args []string) {
+	flags := flag.NewFlagSet("control", flag.ExitOnError)
+	socket := flags.String("control", "", "Unix socket of the farm to control")
+	flags.Parse(args)
+	if *socket == "" || flags.NArg() < 1 || flags.NArg() > 2 {
+		log.Fatal("usage: control -control socket command [worker|package]")
+	}
+
+	req := controlRequest{Command: flags.Arg(0)}
+	switch req.Command {
+	case "add-worker":
+		req.Worker = flags.Arg(1)
+	case "cancel-package":
+		req.Package = flags.Arg(1)
+	}
+
+	conn, err := net.Dial("unix", *socket)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		log.Fatal(err)
+	}
+	var resp controlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		log.Fatal(err)
+	}
+	if resp.Error != "" {
+		log.Fatal(resp.Error)
+	}
+	if resp.Status != nil {
+		out, err := json.MarshalIndent(resp.Status, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(out))
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAddWorkerMidRun(t *testing.T) {
+	server := startFakeServer(t, "127.0.0.2", goTestShell())
+	packages := []string{"state", "api", "worker/uniter"}
+	f := newFakeFarm(t, packages, server)
+	if len(f.Workers()) != 0 {
+		t.Fatalf("the farm started with workers")
+	}
+
+	if _, err := runControl(controlRequest{Command: "add-worker", Worker: server.host}, f); err != nil {
+		t.Fatalf("add-worker: %s", err)
+	}
+	results := collectResults(t, f, len(packages))
+	for _, result := range results {
+		if result.Worker != server.host || !result.Passed {
+			t.Errorf("%s: passed %t on %s, want a pass on %s", result.Package, result.Passed, result.Worker, server.host)
+		}
+	}
+	if got, want := resultPackages(results), []string{"api", "state", "worker/uniter"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tested %q, want %q", got, want)
+	}
+}
+
+func TestAddWorkerErrors(t *testing.T) {
+	f := newFakeFarm(t, nil)
+	if _, err := runControl(controlRequest{Command: "add-worker"}, f); err == nil || err.Error() != "add-worker needs a worker" {
+		t.Errorf("add-worker without a worker: %v", err)
+	}
+
+	f.Close()
+	server := startFakeServer(t, "127.0.0.3", goTestShell())
+	f.newWorker = func(string) *RemoteWorker { return server.worker() }
+	if _, err := runControl(controlRequest{Command: "add-worker", Worker: server.host}, f); err == nil || err.Error() != "the run has finished" {
+		t.Errorf("add-worker after the run: %v", err)
+	}
+	if len(f.Workers()) != 0 {
+		t.Errorf("a worker was added after the run")
+	}
+}
+
+// controlClient sends control requests to a farm over its socket.
+type controlClient struct {
+	t   *testing.T
+	enc *json.Encoder
+	dec *json.Decoder
+}
+
+// startControl serves control requests for f on a unix socket and returns a
+// client connected to it.
+func startControl(t *testing.T, f *farm) *controlClient {
+	// Unix socket paths are short, so this can't be under t.TempDir.
+	dir, err := os.MkdirTemp("", "testfarm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	l, err := net.Listen("unix", filepath.Join(dir, "control.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go serveControl(l, f)
+	conn, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &controlClient{t: t, enc: json.NewEncoder(conn), dec: json.NewDecoder(conn)}
+}
+
+// send sends req and returns the response to it.
+func (c *controlClient) send(req controlRequest) controlResponse {
+	if err := c.enc.Encode(req); err != nil {
+		c.t.Fatal(err)
+	}
+	var resp controlResponse
+	if err := c.dec.Decode(&resp); err != nil {
+		c.t.Fatal(err)
+	}
+	return resp
+}
+
+func TestControlSocket(t *testing.T) {
+	server := startFakeServer(t, "127.0.0.2", goTestShell())
+	packages := []string{"state", "api", "cmd"}
+	f := newFakeFarm(t, packages, server)
+	c := startControl(t, f)
+
+	if resp := c.send(controlRequest{Command: "pause-dispatch"}); resp.Error != "" {
+		t.Fatalf("pause-dispatch: %s", resp.Error)
+	}
+	if resp := c.send(controlRequest{Command: "add-worker", Worker: server.host}); resp.Error != "" {
+		t.Fatalf("add-worker: %s", resp.Error)
+	}
+	resp := c.send(controlRequest{Command: "status"})
+	want := &farmStatus{Paused: true, Queued: 3, Workers: []workerStatus{{Host: server.host}}}
+	if !reflect.DeepEqual(resp.Status, want) {
+		t.Errorf("status = %+v, want %+v", resp.Status, want)
+	}
+	select {
+	case result := <-f.results:
+		t.Fatalf("%s was tested while dispatch was paused", result.Package)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if resp := c.send(controlRequest{Command: "resume"}); resp.Error != "" {
+		t.Fatalf("resume: %s", resp.Error)
+	}
+	if got := resultPackages(collectResults(t, f, len(packages))); !reflect.DeepEqual(got, []string{"api", "cmd", "state"}) {
+		t.Errorf("tested %q after resuming", got)
+	}
+	resp = c.send(controlRequest{Command: "status"})
+	if resp.Status == nil || resp.Status.Paused || resp.Status.Queued != 0 {
+		t.Errorf("status after the run = %+v", resp.Status)
+	}
+}
+
+func TestControlErrors(t *testing.T) {
+	f := newFakeFarm(t, nil)
+	c := startControl(t, f)
+	tests := []struct {
+		req controlRequest
+		err string
+	}{
+		{controlRequest{Command: "restart"}, `unknown command "restart"`},
+		{controlRequest{Command: "cancel-package"}, "cancel-package needs a package"},
+		{controlRequest{Command: "add-worker"}, "add-worker needs a worker"},
+		{controlRequest{Command: "drain"}, ""},
+	}
+	for _, test := range tests {
+		if resp := c.send(test.req); resp.Error != test.err {
+			t.Errorf("%s: error %q, want %q", test.req.Command, resp.Error, test.err)
+		}
+	}
+	if resp := c.send(controlRequest{Command: "status"}); resp.Status == nil || !resp.Status.Stopping {
+		t.Errorf("status after drain = %+v, want stopping", resp.Status)
+	}
+}
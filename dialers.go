@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Dialer opens a connection to addr, a host:port, for an SSH client. It is
+// given the connect timeout from -o ConnectTimeout, which is zero if there
+// isn't one.
+type Dialer func(addr string, timeout time.Duration) (net.Conn, error)
+
+var (
+	dialersMu sync.Mutex
+	dialers   = map[string]Dialer{
+		"tcp": func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("tcp", addr, timeout)
+		},
+	}
+)
+
+// RegisterDialer makes a dialer available under name, for workers that are
+// only reachable over some other transport, such as a mesh VPN with its own
+// dialer. Workers choose a dialer with "dialer" in the inventory; the default
+// is "tcp". Dialers given with -dialer are registered by parseDialers.
+func RegisterDialer(name string, d Dialer) {
+	dialersMu.Lock()
+	defer dialersMu.Unlock()
+	dialers[name] = d
+}
+
+// lookupDialer returns the dialer registered as name, or tcp if name is
+// empty.
+func lookupDialer(name string) (Dialer, error) {
+	if name == "" {
+		name = "tcp"
+	}
+	dialersMu.Lock()
+	defer dialersMu.Unlock()
+	d, ok := dialers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown dialer %q", name)
+	}
+	return d, nil
+}
+
+// dialSSH connects to addr with the dialer called name and starts an SSH
+// client over the connection.
+func dialSSH(name, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	d, err := lookupDialer(name)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := d(addr, config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// parseDialers registers the -dialer definitions, of the form name=command,
+// as command dialers.
+func parseDialers(definitions []string) error {
+	for _, definition := range definitions {
+		name, command, ok := strings.Cut(definition, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.TrimSpace(command) == "" {
+			return fmt.Errorf("dialer %q is not of the form name=command", definition)
+		}
+		if name == "tcp" {
+			return fmt.Errorf("dialer %q: tcp is built in", definition)
+		}
+		RegisterDialer(name, commandDialer(command))
+	}
+	return nil
+}
+
+// proxyCommand expands command the way OpenSSH expands ProxyCommand: %h is
+// the host, %p the port and %% a %.
+func proxyCommand(command, host, port string) string {
+	return strings.NewReplacer("%h", host, "%p", port, "%%", "%").Replace(command)
+}
+
+// commandDialer returns a dialer that runs command, like OpenSSH's
+// ProxyCommand, and uses its stdin and stdout as the connection. Its stderr
+// goes to ours. The connect timeout is up to the command, for example
+// ssh -o ConnectTimeout=10 -W %h:%p jumphost.
+func commandDialer(command string) Dialer {
+	return func(addr string, timeout time.Duration) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		cmd := exec.Command("sh", "-c", proxyCommand(command, host, port))
+		cmd.Stderr = os.Stderr
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, err
+		}
+		return &commandConn{cmd: cmd, stdin: stdin, stdout: stdout, addr: addr}, nil
+	}
+}
+
+// commandConn is a net.Conn over the stdin and stdout of a dialer command.
+// Deadlines aren't supported.
+type commandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	addr   string
+}
+
+func (c *commandConn) Read(p []byte) (int, error)  { return c.stdout.Read(p) }
+func (c *commandConn) Write(p []byte) (int, error) { return c.stdin.Write(p) }
+
+// Close ends the command, which is killed if closing its stdin doesn't make
+// it exit.
+func (c *commandConn) Close() error {
+	c.stdin.Close()
+	c.cmd.Process.Kill()
+	c.cmd.Wait()
+	return nil
+}
+
+func (c *commandConn) LocalAddr() net.Addr                { return commandAddr("testfarm") }
+func (c *commandConn) RemoteAddr() net.Addr               { return commandAddr(c.addr) }
+func (c *commandConn) SetDeadline(t time.Time) error      { return nil }
+func (c *commandConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *commandConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// commandAddr is the address of one end of a commandConn.
+type commandAddr string
+
+func (a commandAddr) Network() string { return "command" }
+func (a commandAddr) String() string  { return string(a) }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// unregisterDialer removes the dialer registered as name when the test ends.
+func unregisterDialer(t *testing.T, name string) {
+	t.Cleanup(func() {
+		dialersMu.Lock()
+		defer dialersMu.Unlock()
+		delete(dialers, name)
+	})
+}
+
+func TestParseDialers(t *testing.T) {
+	tests := []struct {
+		definition string
+		name       string
+		err        string
+	}{
+		{"jump=ssh -W %h:%p jumphost", "jump", ""},
+		{" mesh = tailscale nc %h %p", "mesh", ""},
+		{"jump", "", `dialer "jump" is not of the form name=command`},
+		{"=nc %h %p", "", `dialer "=nc %h %p" is not of the form name=command`},
+		{"jump= ", "", `dialer "jump= " is not of the form name=command`},
+		{"tcp=nc %h %p", "", `dialer "tcp=nc %h %p": tcp is built in`},
+	}
+	for _, test := range tests {
+		if test.name != "" {
+			unregisterDialer(t, test.name)
+		}
+		err := parseDialers([]string{test.definition})
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("parseDialers(%q) error = %v, want %q", test.definition, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseDialers(%q) = %s", test.definition, err)
+		}
+		if _, err := lookupDialer(test.name); err != nil {
+			t.Errorf("parseDialers(%q) didn't register %s: %s", test.definition, test.name, err)
+		}
+	}
+}
+
+func TestLookupDialer(t *testing.T) {
+	if _, err := lookupDialer(""); err != nil {
+		t.Errorf("lookupDialer(\"\") = %s, want tcp", err)
+	}
+	if _, err := lookupDialer("carrier-pigeon"); err == nil || err.Error() != `unknown dialer "carrier-pigeon"` {
+		t.Errorf("lookupDialer(\"carrier-pigeon\") error = %v", err)
+	}
+}
+
+func TestProxyCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"ssh -W %h:%p jumphost", "ssh -W homework1:2222 jumphost"},
+		{"nc %h %p", "nc homework1 2222"},
+		{"printf '100%%' && nc %h %p", "printf '100%' && nc homework1 2222"},
+		{"nc jumphost 22", "nc jumphost 22"},
+	}
+	for _, test := range tests {
+		if got := proxyCommand(test.command, "homework1", "2222"); got != test.want {
+			t.Errorf("proxyCommand(%q) = %q, want %q", test.command, got, test.want)
+		}
+	}
+}
+
+func TestCommandDialer(t *testing.T) {
+	// cat sends back whatever is written to the connection.
+	conn, err := commandDialer("cat")("homework1:22", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if got := conn.RemoteAddr().String(); got != "homework1:22" {
+		t.Errorf("RemoteAddr() = %q, want homework1:22", got)
+	}
+	if _, err := conn.Write([]byte("SSH-2.0-testfarm\n")); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "SSH-2.0-testfarm\n" {
+		t.Errorf("read %q back, want what was written", line)
+	}
+}
+
+func TestRegisteredDialer(t *testing.T) {
+	startFakeAgent(t)
+	shell := newFakeShell(func(string) (string, int) { return "", 0 })
+	server := startFakeServer(t, "127.0.0.2", shell)
+	// The worker is only reachable with the fake dialer, under a name that
+	// doesn't resolve.
+	shell.prompt = "ci@homework9:~/juju$ "
+	var dialed []string
+	unregisterDialer(t, "fake")
+	RegisterDialer("fake", func(addr string, timeout time.Duration) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		return net.DialTimeout("tcp", net.JoinHostPort(server.host, server.port), timeout)
+	})
+	w := server.worker()
+	w.dialer = "fake"
+
+	if err := w.Setup("homework9", &sync.WaitGroup{}); err != nil {
+		t.Fatalf("Setup() = %s", err)
+	}
+	defer w.Close()
+	if want := "homework9:" + server.port; len(dialed) != 1 || dialed[0] != want {
+		t.Errorf("dialed %q, want %s", dialed, want)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import "sync"
+
+// dispatch is shared by a farm's workers. It can hold them back from taking
+// more packages, and stop them testing packages that have been cancelled.
+type dispatch struct {
+	mu sync.Mutex
+	// resumed is closed when dispatch resumes. It is nil unless paused.
+	resumed   chan struct{}
+	cancelled map[string]bool
+}
+
+// Pause stops workers taking packages until Resume is called. Packages in
+// progress carry on.
+func (d *dispatch) Pause() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.resumed == nil {
+		d.resumed = make(chan struct{})
+	}
+}
+
+// Resume lets workers take packages again after Pause.
+func (d *dispatch) Resume() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.resumed != nil {
+		close(d.resumed)
+		d.resumed = nil
+	}
+}
+
+// Paused reports whether dispatch is paused.
+func (d *dispatch) Paused() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.resumed != nil
+}
+
+// wait blocks while dispatch is paused, or until stop is closed.
+func (d *dispatch) wait(stop <-chan struct{}) {
+	d.mu.Lock()
+	resumed := d.resumed
+	d.mu.Unlock()
+	if resumed == nil {
+		return
+	}
+	select {
+	case <-resumed:
+	case <-stop:
+	}
+}
+
+// Cancel stops pkg being tested by any worker that hasn't started it yet.
+func (d *dispatch) Cancel(pkg string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cancelled == nil {
+		d.cancelled = make(map[string]bool)
+	}
+	d.cancelled[pkg] = true
+}
+
+// isCancelled reports whether pkg has been cancelled.
+func (d *dispatch) isCancelled(pkg string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancelled[pkg]
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// fakeServer is an SSH server standing in for a worker. Its shell, whether
+// started as the login shell or with -shell, is a fakeShell. Files uploaded
+// with cat > path are kept in memory.
+type fakeServer struct {
+	host    string
+	port    string
+	shell   *fakeShell
+	hostKey ssh.PublicKey
+
+	mu    sync.Mutex
+	files map[string]string
+	execs []string
+}
+
+// startFakeServer starts a fakeServer for host, which must be a loopback
+// address, with shell as its shell. It stops when the test ends.
+func startFakeServer(t *testing.T, host string, shell *fakeShell) *fakeServer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	shell.prompt = "ci@" + host + ":~/juju$ "
+	s := &fakeServer{host: host, port: port, shell: shell, hostKey: signer.PublicKey(), files: make(map[string]string)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serveConn(conn, config)
+		}
+	}()
+	return s
+}
+
+// Files returns the files uploaded to the server.
+func (s *fakeServer) Files() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	files := make(map[string]string)
+	for name, data := range s.files {
+		files[name] = data
+	}
+	return files
+}
+
+// Execs returns the commands, other than uploads, started with exec.
+func (s *fakeServer) Execs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.execs...)
+}
+
+func (s *fakeServer) serveConn(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go s.serveSession(channel, requests)
+	}
+}
+
+func (s *fakeServer) serveSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	for req := range requests {
+		switch req.Type {
+		case "pty-req":
+			req.Reply(true, nil)
+		case "shell":
+			req.Reply(true, nil)
+			go s.runShell(channel)
+		case "exec":
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			if path, ok := strings.CutPrefix(payload.Command, "cat > "); ok {
+				go s.receiveFile(channel, strings.Trim(path, "'"))
+				continue
+			}
+			s.mu.Lock()
+			s.execs = append(s.execs, payload.Command)
+			s.mu.Unlock()
+			go s.runShell(channel)
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func (s *fakeServer) runShell(channel ssh.Channel) {
+	s.shell.serve(channel, channel)
+	channel.Close()
+}
+
+func (s *fakeServer) receiveFile(channel ssh.Channel, path string) {
+	data, _ := io.ReadAll(channel)
+	s.mu.Lock()
+	s.files[path] = string(data)
+	s.mu.Unlock()
+	channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+	channel.Close()
+}
+
+// worker returns an unconnected worker for the server, logging in as ci.
+func (s *fakeServer) worker() *RemoteWorker {
+	return &RemoteWorker{
+		options:  sshOptions{User: "ci", Port: s.port, StrictHostKeyChecking: "no"},
+		repoPath: defaultRepoPath,
+	}
+}
+
+// startFakeAgent starts an SSH agent with no keys and points SSH_AUTH_SOCK
+// at it for the rest of the test.
+func startFakeAgent(t *testing.T) {
+	// Unix socket paths are short, so this can't be under t.TempDir.
+	dir, err := os.MkdirTemp("", "testfarm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "agent.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	keyring := agent.NewKeyring()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				agent.ServeAgent(keyring, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", socket)
+}
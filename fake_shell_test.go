@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakePrompt is the prompt printed by a fakeShell on homework1.
+const fakePrompt = "ci@homework1:~/juju$ "
+
+// fakeShell stands in for the shell on a worker. It answers each command
+// line with what respond returns for the command, then the exit status that
+// runCommand asks for with its trailing echo, then a prompt. If respond
+// returns a negative status, the command was interrupted and, as with a real
+// shell, the rest of the line isn't run. clearPromptCommand turns the prompt
+// off.
+type fakeShell struct {
+	prompt  string
+	respond func(command string) (string, int)
+	// interrupts gets a value for each Ctrl-C the shell is sent, for a
+	// respond that waits to be interrupted.
+	interrupts chan struct{}
+
+	mu       sync.Mutex
+	commands []string
+}
+
+func newFakeShell(respond func(command string) (string, int)) *fakeShell {
+	return &fakeShell{prompt: fakePrompt, respond: respond, interrupts: make(chan struct{}, 10)}
+}
+
+// Commands returns the commands the shell has run, without the echo of
+// their exit status.
+func (s *fakeShell) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// splitStatusEcho splits a command line sent by runCommand into the command
+// and what it echoes before the exit status, such as statusMarker.
+func splitStatusEcho(line string) (string, string) {
+	if !strings.HasSuffix(line, "$?") {
+		return line, ""
+	}
+	if strings.HasPrefix(line, "echo ") && !strings.Contains(line, ";") {
+		return "", strings.TrimSuffix(strings.TrimPrefix(line, "echo "), "$?")
+	}
+	i := strings.LastIndex(line, "; echo ")
+	if i < 0 {
+		return line, ""
+	}
+	return line[:i], strings.TrimSuffix(line[i+len("; echo "):], "$?")
+}
+
+// serve runs the shell, reading command lines from in and writing to out,
+// until in ends.
+func (s *fakeShell) serve(in io.Reader, out io.Writer) {
+	lines := make(chan string, 100)
+	go func() {
+		defer close(lines)
+		// Ctrl-C is acted on as soon as it arrives, as a terminal would,
+		// rather than with the next line.
+		reader := bufio.NewReader(in)
+		var line strings.Builder
+		for {
+			b, err := reader.ReadByte()
+			if err != nil {
+				return
+			}
+			switch b {
+			case '\x03':
+				select {
+				case s.interrupts <- struct{}{}:
+				default:
+				}
+			case '\n':
+				if text := strings.TrimRight(line.String(), "\r"); text != "" {
+					lines <- text
+				}
+				line.Reset()
+			default:
+				line.WriteByte(b)
+			}
+		}
+	}()
+
+	io.WriteString(out, s.prompt)
+	for line := range lines {
+		command, echo := splitStatusEcho(line)
+		output, status := "", 0
+		if command == "" {
+			// The echo sent again after an interrupt.
+			status = 130
+		} else {
+			s.mu.Lock()
+			s.commands = append(s.commands, command)
+			s.mu.Unlock()
+			if command == clearPromptCommand {
+				s.prompt = ""
+			} else if s.respond != nil {
+				output, status = s.respond(command)
+			}
+		}
+		io.WriteString(out, output)
+		if status >= 0 && echo != "" {
+			if output != "" && !strings.HasSuffix(output, "\n") {
+				io.WriteString(out, "\n")
+			}
+			fmt.Fprintf(out, "%s%d\n", echo, status)
+		}
+		io.WriteString(out, s.prompt)
+	}
+}
+
+// startFakeShell starts s and returns the ends of its stdin and stdout that a
+// RemoteWorker uses. The shell exits when the test ends.
+func startFakeShell(t *testing.T, s *fakeShell) (io.WriteCloser, io.Reader) {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	go func() {
+		s.serve(inR, outW)
+		outW.Close()
+	}()
+	t.Cleanup(func() { inW.Close() })
+	return inW, outR
+}
+
+// newFakeWorker returns a worker on homework1, as ci, whose shell is s. It has
+// read the first prompt, as Setup would have.
+func newFakeWorker(t *testing.T, s *fakeShell) *RemoteWorker {
+	stdin, stdout := startFakeShell(t, s)
+	matches, err := compilePromptPatterns("ci", "homework1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &RemoteWorker{
+		host:          "homework1",
+		stdin:         stdin,
+		reader:        bufio.NewReader(stdout),
+		promptMatches: matches,
+		repoPath:      defaultRepoPath,
+	}
+	r.waitForPrompt()
+	return r
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// farm is the set of workers testing the queued packages. Workers can be
+// added to it at any time until the run finishes.
+type farm struct {
+	// newWorker returns an unconnected worker for host with the run's
+	// settings.
+	newWorker func(host string) *RemoteWorker
+	// warmup is run on each worker after it connects. A worker it returns
+	// an error for is not used.
+	warmup func(w *RemoteWorker) error
+
+	unitQueue          chan string
+	integrationQueue   chan string
+	pinnedQueues       map[string]chan string
+	integrationWorkers map[string]bool
+	results            chan Result
+	incidents          *incidentLog
+	// fixtureQueues hold the packages that need fixtures, keyed by
+	// fixtureKey, and workerFixtures the fixtures each worker has.
+	fixtureQueues  map[string]chan string
+	workerFixtures map[string]map[string]bool
+	// serial limits the farm to one worker.
+	serial bool
+	// stop is closed to tell workers to stop taking packages.
+	stop     chan struct{}
+	stopOnce sync.Once
+	// dispatch pauses and cancels packages for every worker.
+	dispatch *dispatch
+
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	workers []*RemoteWorker
+	closed  bool
+	// envs are the environments captured from workers, kept for those
+	// excluded after capturing it too.
+	envs map[string]*remoteEnv
+}
+
+// canRunIntegration reports whether host may run integration packages. If no
+// integration workers were given, every worker may.
+func (f *farm) canRunIntegration(host string) bool {
+	return len(f.integrationWorkers) == 0 || f.integrationWorkers[host]
+}
+
+// fixtureQueuesFor returns the queues of packages needing fixtures that host
+// has.
+func (f *farm) fixtureQueuesFor(host string) []chan string {
+	var keys []string
+	for key := range f.fixtureQueues {
+		if hasFixtures(f.workerFixtures[host], key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var queues []chan string
+	for _, key := range keys {
+		queues = append(queues, f.fixtureQueues[key])
+	}
+	return queues
+}
+
+// AddWorker connects to host, warms it up and starts it testing packages. A
+// worker that can't be used is recorded as an incident.
+func (f *farm) AddWorker(host string) error {
+	w := f.newWorker(host)
+	if err := w.Setup(host, &f.wg); err != nil {
+		w.Close()
+		f.incidents.Record(host, incidentWorkerExcluded, err.Error())
+		return err
+	}
+	err := f.warmup(w)
+	f.recordEnv(w)
+	if err != nil {
+		if history := w.history.report(host); history != "" {
+			log.Print(history)
+		}
+		w.Close()
+		f.incidents.Record(host, incidentWorkerExcluded, err.Error())
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed || f.stopping() {
+		w.Close()
+		return errors.New("the run has finished")
+	}
+	if f.serial && len(f.workers) > 0 {
+		w.Close()
+		return errors.New("the run is serial and already has a worker")
+	}
+	f.workers = append(f.workers, w)
+
+	// Workers that can run integration packages take those first, since no
+	// one else can, then help out with the unit packages. Packages pinned to
+	// a worker come before either, then those needing fixtures it has.
+	queues := []chan string{f.unitQueue}
+	if f.canRunIntegration(host) {
+		queues = []chan string{f.integrationQueue, f.unitQueue}
+	}
+	queues = append(f.fixtureQueuesFor(host), queues...)
+	if pinned, ok := f.pinnedQueues[host]; ok {
+		queues = append([]chan string{pinned}, queues...)
+	}
+	w.stop = f.stop
+	w.dispatch = f.dispatch
+	f.wg.Add(1)
+	go w.TestPackages(queues, f.results)
+	return nil
+}
+
+// recordEnv keeps the environment captured from w during its warmup, if any.
+func (f *farm) recordEnv(w *RemoteWorker) {
+	if w.env == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.envs == nil {
+		f.envs = make(map[string]*remoteEnv)
+	}
+	f.envs[w.host] = w.env
+}
+
+// Envs returns the environments captured from workers, whether or not they
+// were used, by host.
+func (f *farm) Envs() map[string]*remoteEnv {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	envs := make(map[string]*remoteEnv)
+	for host, env := range f.envs {
+		envs[host] = env
+	}
+	return envs
+}
+
+// Workers returns the workers that have been added.
+func (f *farm) Workers() []*RemoteWorker {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*RemoteWorker(nil), f.workers...)
+}
+
+// farmStatus is a snapshot of a farm, as reported by the status control
+// command.
+type farmStatus struct {
+	Paused   bool           `json:"paused"`
+	Stopping bool           `json:"stopping"`
+	Queued   int            `json:"queued"`
+	Workers  []workerStatus `json:"workers"`
+}
+
+// workerStatus is the part of a farmStatus about one worker. Package is
+// empty if the worker is between packages.
+type workerStatus struct {
+	Host    string `json:"host"`
+	Package string `json:"package,omitempty"`
+}
+
+// Status returns a snapshot of the farm. Queued counts the packages no worker
+// has taken yet.
+func (f *farm) Status() farmStatus {
+	status := farmStatus{
+		Paused:   f.dispatch.Paused(),
+		Stopping: f.stopping(),
+		Queued:   len(f.unitQueue) + len(f.integrationQueue),
+	}
+	for _, pinned := range f.pinnedQueues {
+		status.Queued += len(pinned)
+	}
+	for _, queue := range f.fixtureQueues {
+		status.Queued += len(queue)
+	}
+	for _, w := range f.Workers() {
+		status.Workers = append(status.Workers, workerStatus{Host: w.host, Package: w.Current()})
+	}
+	return status
+}
+
+// CancelPackage stops pkg being tested. Workers that haven't started it skip
+// it, and any worker testing it is interrupted. Either way its result is
+// marked cancelled.
+func (f *farm) CancelPackage(pkg string) {
+	f.dispatch.Cancel(pkg)
+	for _, w := range f.Workers() {
+		if w.cancel(pkg) {
+			log.Printf("interrupted %s on %s", pkg, w.host)
+		}
+	}
+}
+
+// StopDispatch tells every worker to stop once it has finished the package it
+// is testing.
+func (f *farm) StopDispatch() {
+	f.stopOnce.Do(func() { close(f.stop) })
+}
+
+func (f *farm) stopping() bool {
+	select {
+	case <-f.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Kill ends every worker's session, and with it any package in progress.
+func (f *farm) Kill() {
+	f.StopDispatch()
+	for _, w := range f.Workers() {
+		w.kill()
+	}
+}
+
+// drain stops dispatch at the first signal, then waits for done to be closed,
+// once the workers have finished the packages in progress. Those still in
+// progress after timeout, or a second signal, are killed.
+func (f *farm) drain(signals <-chan os.Signal, timeout time.Duration, done <-chan struct{}) {
+	<-signals
+	log.Printf("stopping: waiting up to %s for packages in progress", timeout)
+	f.StopDispatch()
+	select {
+	case <-done:
+		return
+	case <-signals:
+	case <-time.After(timeout):
+	}
+	log.Print("killing packages still in progress")
+	f.Kill()
+}
+
+// Wait waits for the workers running to finish.
+func (f *farm) Wait() {
+	f.wg.Wait()
+}
+
+// Close stops workers being added and waits for the ones running to finish.
+func (f *farm) Close() {
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+	f.wg.Wait()
+}
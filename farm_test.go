@@ -0,0 +1,344 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// goTestShell returns a fakeShell in which go test passes in every package,
+// printing the ok line for the package it was run in.
+func goTestShell() *fakeShell {
+	dir := ""
+	return newFakeShell(func(command string) (string, int) {
+		switch {
+		case strings.HasPrefix(command, "cd "):
+			dir = strings.TrimPrefix(command, "cd ")
+		case strings.HasPrefix(command, "go test"):
+			return "ok  \tgithub.com/juju/juju/" + dir + "\t0.012s\n", 0
+		}
+		return "", 0
+	})
+}
+
+// newFakeFarm returns a farm that tests packages, all unit packages, on the
+// workers given by servers. None of them have been added to it.
+func newFakeFarm(t *testing.T, packages []string, servers ...*fakeServer) *farm {
+	startFakeAgent(t)
+	unit := make(chan string, len(packages))
+	for _, pkg := range packages {
+		unit <- pkg
+	}
+	close(unit)
+	integration := make(chan string)
+	close(integration)
+	byHost := make(map[string]*fakeServer)
+	for _, s := range servers {
+		byHost[s.host] = s
+	}
+	f := &farm{
+		newWorker:        func(host string) *RemoteWorker { return byHost[host].worker() },
+		warmup:           func(*RemoteWorker) error { return nil },
+		unitQueue:        unit,
+		integrationQueue: integration,
+		results:          make(chan Result, len(packages)),
+		incidents:        &incidentLog{},
+		stop:             make(chan struct{}),
+		dispatch:         &dispatch{},
+	}
+	t.Cleanup(func() {
+		f.Kill()
+		f.Wait()
+	})
+	return f
+}
+
+// collectResults waits for n results from f.
+func collectResults(t *testing.T, f *farm, n int) []Result {
+	var results []Result
+	for len(results) < n {
+		select {
+		case result := <-f.results:
+			results = append(results, result)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("got %d of %d results", len(results), n)
+		}
+	}
+	return results
+}
+
+// resultPackages returns the packages of results, sorted.
+func resultPackages(results []Result) []string {
+	var packages []string
+	for _, result := range results {
+		packages = append(packages, result.Package)
+	}
+	sort.Strings(packages)
+	return packages
+}
+
+func TestPinnedPackagesOnlyRunOnTheirWorker(t *testing.T) {
+	pinnedTo := startFakeServer(t, "127.0.0.2", goTestShell())
+	other := startFakeServer(t, "127.0.0.3", goTestShell())
+	unpinned := []string{"cmd", "rpc", "network", "version", "lease"}
+	f := newFakeFarm(t, unpinned, pinnedTo, other)
+	pinned := make(chan string, 2)
+	pinned <- "state"
+	pinned <- "api"
+	close(pinned)
+	f.pinnedQueues = map[string]chan string{pinnedTo.host: pinned}
+
+	// The other worker starts first, so it would take the pinned packages
+	// if it could.
+	for _, host := range []string{other.host, pinnedTo.host} {
+		if err := f.AddWorker(host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	results := collectResults(t, f, len(unpinned)+2)
+	for _, result := range results {
+		if (result.Package == "state" || result.Package == "api") && result.Worker != pinnedTo.host {
+			t.Errorf("%s, pinned to %s, was tested on %s", result.Package, pinnedTo.host, result.Worker)
+		}
+	}
+	if got, want := resultPackages(results), []string{"api", "cmd", "lease", "network", "rpc", "state", "version"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tested %q, want %q", got, want)
+	}
+}
+
+func TestSmokeFailureExcludesWorker(t *testing.T) {
+	good := startFakeServer(t, "127.0.0.2", goTestShell())
+	bad := startFakeServer(t, "127.0.0.3", newFakeShell(func(command string) (string, int) {
+		if strings.HasPrefix(command, "go test") {
+			return "--- FAIL: TestAddUnit (0.01s)\nFAIL\n", 1
+		}
+		return "", 0
+	}))
+	packages := []string{"state", "api", "cmd"}
+	f := newFakeFarm(t, packages, good, bad)
+	f.warmup = func(w *RemoteWorker) error { return w.smokeTest("state") }
+
+	err := f.AddWorker(bad.host)
+	if err == nil || !strings.HasPrefix(err.Error(), "smoke test of state failed:\n") {
+		t.Fatalf("AddWorker(%s) = %v, want a smoke test failure", bad.host, err)
+	}
+	if err := f.AddWorker(good.host); err != nil {
+		t.Fatalf("AddWorker(%s) = %s", good.host, err)
+	}
+	if workers := f.Workers(); len(workers) != 1 || workers[0].host != good.host {
+		t.Errorf("workers are %v, want only %s", workers, good.host)
+	}
+	incidents := f.incidents.Incidents()
+	if len(incidents) != 1 || incidents[0].Worker != bad.host || incidents[0].Kind != incidentWorkerExcluded {
+		t.Errorf("incidents are %+v, want %s excluded", incidents, bad.host)
+	}
+	for _, result := range collectResults(t, f, len(packages)) {
+		if result.Worker != good.host || !result.Passed {
+			t.Errorf("%s: passed %t on %s", result.Package, result.Passed, result.Worker)
+		}
+	}
+}
+
+func TestSerialRunsInOrderOnOneWorker(t *testing.T) {
+	shell := goTestShell()
+	first := startFakeServer(t, "127.0.0.2", shell)
+	second := startFakeServer(t, "127.0.0.3", goTestShell())
+	order := []string{"version", "state", "featuretests", "api"}
+	f := newFakeFarm(t, order, first, second)
+	f.serial = true
+
+	if err := f.AddWorker(first.host); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.AddWorker(second.host); err == nil || err.Error() != "the run is serial and already has a worker" {
+		t.Errorf("AddWorker(%s) = %v, want it refused", second.host, err)
+	}
+	var tested []string
+	for _, result := range collectResults(t, f, len(order)) {
+		if result.Worker != first.host {
+			t.Errorf("%s was tested on %s", result.Package, result.Worker)
+		}
+		tested = append(tested, result.Package)
+	}
+	if !reflect.DeepEqual(tested, order) {
+		t.Errorf("tested %q, want %q", tested, order)
+	}
+	// Each package is finished before the next is started.
+	var commands []string
+	for _, command := range shell.Commands() {
+		if command != "cd "+defaultRepoPath {
+			commands = append(commands, strings.Fields(command)[0]+" "+strings.Fields(command)[1])
+		}
+	}
+	var want []string
+	for _, pkg := range order {
+		want = append(want, "cd "+pkg, "go test")
+	}
+	if !reflect.DeepEqual(commands, want) {
+		t.Errorf("ran %q, want %q", commands, want)
+	}
+}
+
+// blockingShell returns a fakeShell in which go test doesn't finish until
+// release is closed, and started receives each package as it starts.
+func blockingShell(t *testing.T) (shell *fakeShell, started chan string) {
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	started = make(chan string, 10)
+	dir := ""
+	return newFakeShell(func(command string) (string, int) {
+		switch {
+		case strings.HasPrefix(command, "cd "):
+			dir = strings.TrimPrefix(command, "cd ")
+		case strings.HasPrefix(command, "go test"):
+			started <- dir
+			<-release
+			return "ok  \tgithub.com/juju/juju/" + dir + "\t3600s\n", 0
+		}
+		return "", 0
+	}), started
+}
+
+func TestDrain(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		signals int
+	}{
+		{"timeout", 50 * time.Millisecond, 1},
+		{"second signal", time.Hour, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			shell, started := blockingShell(t)
+			server := startFakeServer(t, "127.0.0.2", shell)
+			f := newFakeFarm(t, []string{"state", "api"}, server)
+			if err := f.AddWorker(server.host); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-started:
+			case <-time.After(10 * time.Second):
+				t.Fatal("go test wasn't started")
+			}
+
+			done := make(chan struct{})
+			go func() {
+				f.Wait()
+				close(done)
+			}()
+			signals := make(chan os.Signal, 2)
+			for i := 0; i < test.signals; i++ {
+				signals <- os.Interrupt
+			}
+			drained := make(chan struct{})
+			go func() {
+				f.drain(signals, test.timeout, done)
+				close(drained)
+			}()
+			select {
+			case <-drained:
+			case <-time.After(10 * time.Second):
+				t.Fatal("drain didn't return")
+			}
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("the worker wasn't killed")
+			}
+			if len(started) != 0 {
+				t.Errorf("%s was started after the signal", <-started)
+			}
+		})
+	}
+}
+
+func TestDrainFinishesPackagesInProgress(t *testing.T) {
+	started := make(chan struct{}, 2)
+	shell := newFakeShell(func(command string) (string, int) {
+		if strings.HasPrefix(command, "go test") {
+			started <- struct{}{}
+			time.Sleep(100 * time.Millisecond)
+			return "ok  \tgithub.com/juju/juju/state\t0.100s\n", 0
+		}
+		return "", 0
+	})
+	server := startFakeServer(t, "127.0.0.2", shell)
+	f := newFakeFarm(t, []string{"state", "api"}, server)
+	if err := f.AddWorker(server.host); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		f.Wait()
+		close(done)
+	}()
+	select {
+	case <-started:
+	case <-time.After(10 * time.Second):
+		t.Fatal("go test wasn't started")
+	}
+	signals := make(chan os.Signal, 1)
+	signals <- os.Interrupt
+	f.drain(signals, time.Hour, done)
+
+	results := collectResults(t, f, 1)
+	if !results[0].Passed {
+		t.Errorf("%s was killed rather than finishing", results[0].Package)
+	}
+	if len(f.results) != 0 || len(started) != 0 {
+		t.Errorf("another package was tested after the signal")
+	}
+}
+
+func TestCancelPackage(t *testing.T) {
+	started := make(chan struct{}, 1)
+	dir := ""
+	var shell *fakeShell
+	shell = newFakeShell(func(command string) (string, int) {
+		switch {
+		case strings.HasPrefix(command, "cd "):
+			dir = strings.TrimPrefix(command, "cd ")
+		case strings.HasPrefix(command, "go test") && dir == "state":
+			// state runs until it is interrupted.
+			started <- struct{}{}
+			<-shell.interrupts
+			return "^C\n", -1
+		case strings.HasPrefix(command, "go test"):
+			return "ok  \tgithub.com/juju/juju/" + dir + "\t0.012s\n", 0
+		}
+		return "", 0
+	})
+	server := startFakeServer(t, "127.0.0.2", shell)
+	f := newFakeFarm(t, []string{"state", "api", "cmd"}, server)
+	// cmd is cancelled before any worker starts it.
+	f.CancelPackage("cmd")
+	if err := f.AddWorker(server.host); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-started:
+	case <-time.After(10 * time.Second):
+		t.Fatal("state wasn't started")
+	}
+	f.CancelPackage("state")
+
+	results := make(map[string]Result)
+	for _, result := range collectResults(t, f, 3) {
+		results[result.Package] = result
+	}
+	for pkg, cancelled := range map[string]bool{"state": true, "api": false, "cmd": true} {
+		result := results[pkg]
+		if result.Cancelled != cancelled || result.Passed == cancelled {
+			t.Errorf("%s: cancelled %t, passed %t, want cancelled %t", pkg, result.Cancelled, result.Passed, cancelled)
+		}
+	}
+	for _, command := range shell.Commands() {
+		if command == "cd cmd" {
+			t.Errorf("cmd was tested after it was cancelled")
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// commandHistory keeps the last limit commands sent to a worker, to show the
+// state it was in when something failed. It is safe to use from several
+// goroutines, since an interrupt can be sent while a command runs.
+type commandHistory struct {
+	limit    int
+	mu       sync.Mutex
+	commands []string
+}
+
+// add records command, forgetting the oldest once there are limit of them.
+func (h *commandHistory) add(command string) {
+	if h.limit <= 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.commands) == h.limit {
+		copy(h.commands, h.commands[1:])
+		h.commands = h.commands[:len(h.commands)-1]
+	}
+	h.commands = append(h.commands, command)
+}
+
+// report describes the recorded commands, oldest first, for adding to the
+// output of a failure on host. It is empty if there are none.
+func (h *commandHistory) report(host string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.commands) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "testfarm: last %d commands sent to %s:\n", len(h.commands), host)
+	for _, command := range h.commands {
+		fmt.Fprintf(&b, "  %s\n", command)
+	}
+	return b.String()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCommandHistory(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    int
+		commands []string
+		want     []string
+	}{
+		{"under the limit", 3, []string{"cd state", "go test"}, []string{"cd state", "go test"}},
+		{"over the limit", 2, []string{"cd /home/ci/juju", "cd state", "go test"}, []string{"cd state", "go test"}},
+		{"off", 0, []string{"cd state"}, nil},
+	}
+	for _, test := range tests {
+		h := &commandHistory{limit: test.limit}
+		for _, command := range test.commands {
+			h.add(command)
+		}
+		if !reflect.DeepEqual(h.commands, test.want) {
+			t.Errorf("%s: kept %q, want %q", test.name, h.commands, test.want)
+		}
+	}
+}
+
+func TestCommandHistoryReport(t *testing.T) {
+	h := &commandHistory{limit: 5}
+	if got := h.report("homework1"); got != "" {
+		t.Errorf("report() = %q with no commands", got)
+	}
+	h.add("cd state")
+	h.add("go test ./...")
+	want := "testfarm: last 2 commands sent to homework1:\n  cd state\n  go test ./...\n"
+	if got := h.report("homework1"); got != want {
+		t.Errorf("report() = %q, want %q", got, want)
+	}
+}
+
+func TestFailureReportsHistory(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		status int
+		report bool
+	}{
+		{"passes", "ok  \tgithub.com/juju/juju/state\t0.012s\n", 0, false},
+		{"fails", "--- FAIL: TestAddUnit (0.01s)\nFAIL", 1, true},
+		// The kernel log is read after a kill, but the history still
+		// ends with go test.
+		{"killed", "signal: killed\nFAIL", 1, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			shell := newFakeShell(func(command string) (string, int) {
+				if strings.HasPrefix(command, "go test") {
+					return test.output, test.status
+				}
+				return "", 0
+			})
+			r := newFakeWorker(t, shell)
+			r.history.limit = 2
+			result := r.runPackage("state")
+			// The commands are as they were sent, with the echo of their
+			// exit status.
+			want := "testfarm: last 2 commands sent to homework1:\n" +
+				"  cd state; echo testfarm-status:$?\n" +
+				"  go test -test.timeout=20m0s ./...; echo testfarm-status:$?\n"
+			if got := strings.HasSuffix(result.Output, want); got != test.report {
+				t.Errorf("output is %q, want history %t", result.Output, test.report)
+			}
+			if test.report && !strings.Contains(result.Output, "FAIL\ntestfarm: last 2") {
+				t.Errorf("history isn't on a line of its own in %q", result.Output)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"os"
+	"time"
+)
+
+// htmlReport is the template for -html-out. It is self-contained so the
+// report can be passed around as a single file. Clicking a column heading
+// sorts the table by that column.
+var htmlReport = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Test farm report</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { padding: 4px 8px; border-bottom: 1px solid #ddd; text-align: left; vertical-align: top; }
+th { cursor: pointer; }
+.pass { color: #080; }
+.fail { color: #c00; }
+pre { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>Test farm report</h1>
+<p>{{.Generated.Format "2006-01-02 15:04:05 MST"}}: {{.Passed}} passed, {{.Failed}} failed.</p>
+{{if .Commit}}<p>Commit {{.Commit}}</p>{{end}}
+<table id="results">
+<thead>
+<tr><th>Package</th><th>Status</th><th>Duration</th><th>Worker</th><th>Output</th></tr>
+</thead>
+<tbody>
+{{range .Results}}<tr>
+<td>{{.Package}}</td>
+<td class="{{.Status}}">{{.Status}}</td>
+<td data-sort="{{.Duration.Seconds}}">{{.Duration}}</td>
+<td>{{.Worker}}</td>
+<td>{{if .Passed}}<details><summary>output</summary><pre>{{.Output}}</pre></details>{{else}}<details open><summary>output</summary><pre>{{.Output}}</pre></details>{{end}}</td>
+</tr>
+{{end}}</tbody>
+</table>
+<script>
+document.querySelectorAll("#results th").forEach(function(th, column) {
+	var ascending = true;
+	th.addEventListener("click", function() {
+		var body = document.querySelector("#results tbody");
+		var rows = Array.from(body.rows);
+		rows.sort(function(a, b) {
+			var x = a.cells[column].dataset.sort || a.cells[column].textContent;
+			var y = b.cells[column].dataset.sort || b.cells[column].textContent;
+			var n = parseFloat(x) - parseFloat(y);
+			var order = isNaN(n) ? x.localeCompare(y) : n;
+			return ascending ? order : -order;
+		});
+		ascending = !ascending;
+		rows.forEach(function(row) { body.appendChild(row); });
+	});
+});
+</script>
+</body>
+</html>
+`))
+
+// writeHTMLReport writes an HTML report of results, run against commit, to w.
+func writeHTMLReport(w io.Writer, results []Result, commit string) error {
+	data := struct {
+		Generated      time.Time
+		Commit         string
+		Results        []Result
+		Passed, Failed int
+	}{Generated: time.Now(), Commit: commit, Results: results}
+	for _, result := range results {
+		switch {
+		case result.Cancelled:
+		case result.Passed:
+			data.Passed++
+		default:
+			data.Failed++
+		}
+	}
+	return htmlReport.Execute(w, data)
+}
+
+// writeHTMLReportFile writes an HTML report of results to the file path.
+func writeHTMLReportFile(path string, results []Result, commit string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeHTMLReport(f, results, commit); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteHTMLReport(t *testing.T) {
+	results := []Result{
+		{Package: "state", Worker: "homework1", Passed: true, Duration: 12 * time.Second, Output: "ok\n"},
+		{Package: "api", Worker: "homework2", Duration: 3 * time.Second, Output: "--- FAIL: TestLogin <nil>\n"},
+		{Package: "worker/uniter", Worker: "homework1", Passed: true, Duration: time.Second},
+		{Package: "cmd", Worker: "homework2", Cancelled: true},
+	}
+	var out bytes.Buffer
+	if err := writeHTMLReport(&out, results, "0123abcd"); err != nil {
+		t.Fatal(err)
+	}
+	report := out.String()
+	for _, want := range []string{
+		"<td>state</td>\n<td class=\"pass\">pass</td>",
+		"<td>api</td>\n<td class=\"fail\">fail</td>",
+		"<td>worker/uniter</td>\n<td class=\"pass\">pass</td>",
+		"<td>cmd</td>\n<td class=\"cancelled\">cancelled</td>",
+		"2 passed, 1 failed.",
+		"<p>Commit 0123abcd</p>",
+		// Output is escaped, and open for failures.
+		"<details open><summary>output</summary><pre>--- FAIL: TestLogin &lt;nil&gt;\n</pre>",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report doesn't have %q", want)
+		}
+	}
+}
+
+func TestWriteHTMLReportFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.html")
+	if err := writeHTMLReportFile(path, []Result{{Package: "state", Passed: true}}, ""); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "<td>state</td>") || strings.Contains(string(data), "Commit") {
+		t.Errorf("unexpected report:\n%s", data)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Kinds of infrastructure incident.
+const (
+	incidentWorkerExcluded = "worker-excluded"
+	incidentOOMKilled      = "oom-killed"
+	incidentClockSkew      = "clock-skew"
+	incidentWorkerLost     = "worker-lost"
+)
+
+// incident is something that went wrong with the infrastructure during a
+// run, as opposed to a test failing.
+type incident struct {
+	Time   time.Time
+	Worker string
+	Kind   string
+	Detail string
+}
+
+// incidentLog collects the incidents of a run. It is safe to use from
+// several goroutines.
+type incidentLog struct {
+	mu        sync.Mutex
+	incidents []incident
+}
+
+// Record adds an incident of kind on worker to the log.
+func (l *incidentLog) Record(worker, kind, detail string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.incidents = append(l.incidents, incident{
+		Time:   time.Now(),
+		Worker: worker,
+		Kind:   kind,
+		Detail: detail,
+	})
+}
+
+// Incidents returns the incidents recorded so far, oldest first.
+func (l *incidentLog) Incidents() []incident {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]incident(nil), l.incidents...)
+}
+
+// writeSummary writes the number of incidents of each kind, then every
+// incident, to w. It writes nothing if there were none.
+func (l *incidentLog) writeSummary(w io.Writer) {
+	incidents := l.Incidents()
+	if len(incidents) == 0 {
+		return
+	}
+	counts := make(map[string]int)
+	for _, i := range incidents {
+		counts[i.Kind]++
+	}
+	var kinds []string
+	for kind := range counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	fmt.Fprintf(w, "infrastructure incidents: %d\n", len(incidents))
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "  %s: %d\n", kind, counts[kind])
+	}
+	for _, i := range incidents {
+		fmt.Fprintf(w, "  %s %s %s: %s\n", i.Time.Format("15:04:05"), i.Worker, i.Kind, i.Detail)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"sync"
+	"testing"
+)
+
+func TestIncidentLog(t *testing.T) {
+	l := &incidentLog{}
+	var wg sync.WaitGroup
+	for _, worker := range []string{"homework1", "homework2", "homework4"} {
+		wg.Add(1)
+		go func(worker string) {
+			defer wg.Done()
+			l.Record(worker, incidentWorkerExcluded, "unable to connect")
+		}(worker)
+	}
+	wg.Wait()
+	l.Record("homework1", incidentOOMKilled, "state")
+	l.Record("homework2", incidentClockSkew, "3s")
+
+	incidents := l.Incidents()
+	if len(incidents) != 5 {
+		t.Fatalf("recorded %d incidents, want 5", len(incidents))
+	}
+	if last := incidents[4]; last.Worker != "homework2" || last.Kind != incidentClockSkew || last.Detail != "3s" {
+		t.Errorf("last incident = %+v", last)
+	}
+
+	var out bytes.Buffer
+	l.writeSummary(&out)
+	// Counts come first, by kind, then each incident in the order it
+	// happened.
+	want := regexp.MustCompile(`^infrastructure incidents: 5
+  clock-skew: 1
+  oom-killed: 1
+  worker-excluded: 3
+(  \d\d:\d\d:\d\d homework\d worker-excluded: unable to connect
+){3}  \d\d:\d\d:\d\d homework1 oom-killed: state
+  \d\d:\d\d:\d\d homework2 clock-skew: 3s
+$`)
+	if !want.MatchString(out.String()) {
+		t.Errorf("summary is\n%s", out.String())
+	}
+}
+
+func TestIncidentLogSummaryEmpty(t *testing.T) {
+	var out bytes.Buffer
+	(&incidentLog{}).writeSummary(&out)
+	if out.Len() != 0 {
+		t.Errorf("summary of no incidents is %q", out.String())
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// inventory describes the workers and packages for a run, for when flags
+// aren't enough. It is read from a JSON file given with -inventory:
+//
+//	{
+//		"workers": [
+//			{"host": "homework1", "user": "ci", "port": 2222, "tags": ["integration"]},
+//			{"host": "homework3", "dialer": "tailscale", "repo_path": "/srv/juju", "go_version": "go1.22.1"},
+//			{"host": "homework2", "fixtures": ["charm-store"]}
+//		],
+//		"packages": [
+//			{"name": "featuretests", "tags": ["integration"], "timeout": "40m"},
+//			{"name": "charmrepo", "fixtures": ["charm-store"]},
+//			{"name": "state"}
+//		],
+//		"groups": [
+//			{"name": "core", "packages": ["state", "api"], "timeout": "30m"}
+//		]
+//	}
+//
+// A worker or package tagged "integration" runs integration packages, as if
+// listed in -integration-workers or -integration-packages. A package with
+// fixtures is only tested on workers that have all of them locally. Groups can
+// be used as @name aliases and pass their tags on to their packages. A timeout
+// is used for go test in place of the default, but not of one from -timeouts.
+type inventory struct {
+	Workers  []inventoryWorker  `json:"workers"`
+	Packages []inventoryPackage `json:"packages"`
+	Groups   []inventoryGroup   `json:"groups"`
+}
+
+// inventoryWorker is a worker in an inventory. User and Port override the
+// -o options of the same name for this worker. Dialer names the registered
+// Dialer used to reach it. RepoPath is where the juju source is on it, if not
+// in the usual place. GoVersion, if set, is the go version, as reported by go
+// env GOVERSION, that it must have to be used.
+type inventoryWorker struct {
+	Host      string   `json:"host"`
+	User      string   `json:"user,omitempty"`
+	Port      int      `json:"port,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	Dialer    string   `json:"dialer,omitempty"`
+	Fixtures  []string `json:"fixtures,omitempty"`
+	RepoPath  string   `json:"repo_path,omitempty"`
+	GoVersion string   `json:"go_version,omitempty"`
+}
+
+// inventoryPackage is a package in an inventory. Fixtures are the local data
+// a worker needs to test it.
+type inventoryPackage struct {
+	Name     string   `json:"name"`
+	Tags     []string `json:"tags,omitempty"`
+	Fixtures []string `json:"fixtures,omitempty"`
+	Timeout  string   `json:"timeout,omitempty"`
+}
+
+// inventoryGroup is a named set of packages in an inventory. Its timeout is
+// for those of its packages that don't have their own.
+type inventoryGroup struct {
+	Name     string   `json:"name"`
+	Packages []string `json:"packages"`
+	Tags     []string `json:"tags,omitempty"`
+	Timeout  string   `json:"timeout,omitempty"`
+}
+
+// integrationTag marks workers and packages for integration tests.
+const integrationTag = "integration"
+
+// readInventory reads and validates an inventory.
+func readInventory(r io.Reader) (*inventory, error) {
+	var inv inventory
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&inv); err != nil {
+		return nil, err
+	}
+	if err := inv.validate(); err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// loadInventory reads the inventory in the file path.
+func loadInventory(path string) (*inventory, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	inv, err := readInventory(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return inv, nil
+}
+
+func (inv *inventory) validate() error {
+	hosts := make(map[string]bool)
+	for i, w := range inv.Workers {
+		if w.Host == "" {
+			return fmt.Errorf("worker %d has no host", i+1)
+		}
+		if hosts[w.Host] {
+			return fmt.Errorf("worker %s is listed twice", w.Host)
+		}
+		hosts[w.Host] = true
+		if w.Port < 0 || w.Port > 65535 {
+			return fmt.Errorf("worker %s has bad port %d", w.Host, w.Port)
+		}
+		if _, err := lookupDialer(w.Dialer); err != nil {
+			return fmt.Errorf("worker %s: %s", w.Host, err)
+		}
+	}
+	names := make(map[string]bool)
+	for i, p := range inv.Packages {
+		if p.Name == "" {
+			return fmt.Errorf("package %d has no name", i+1)
+		}
+		if names[p.Name] {
+			return fmt.Errorf("package %s is listed twice", p.Name)
+		}
+		names[p.Name] = true
+		if err := validTimeout(p.Timeout); err != nil {
+			return fmt.Errorf("package %s has %s", p.Name, err)
+		}
+	}
+	groups := make(map[string]bool)
+	for i, g := range inv.Groups {
+		if g.Name == "" {
+			return fmt.Errorf("group %d has no name", i+1)
+		}
+		if groups[g.Name] {
+			return fmt.Errorf("group %s is listed twice", g.Name)
+		}
+		groups[g.Name] = true
+		if len(g.Packages) == 0 {
+			return fmt.Errorf("group %s has no packages", g.Name)
+		}
+		if err := validTimeout(g.Timeout); err != nil {
+			return fmt.Errorf("group %s has %s", g.Name, err)
+		}
+	}
+	return nil
+}
+
+// validTimeout checks that timeout, if set, is a positive duration.
+func validTimeout(timeout string) error {
+	if timeout == "" {
+		return nil
+	}
+	if d, err := time.ParseDuration(timeout); err != nil || d <= 0 {
+		return fmt.Errorf("bad timeout %q", timeout)
+	}
+	return nil
+}
+
+// hosts returns the host of every worker, in order.
+func (inv *inventory) hosts() []string {
+	var hosts []string
+	for _, w := range inv.Workers {
+		hosts = append(hosts, w.Host)
+	}
+	return hosts
+}
+
+// packageNames returns the name of every package, in order.
+func (inv *inventory) packageNames() []string {
+	var names []string
+	for _, p := range inv.Packages {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// worker returns the inventory entry for host.
+func (inv *inventory) worker(host string) (inventoryWorker, bool) {
+	for _, w := range inv.Workers {
+		if w.Host == host {
+			return w, true
+		}
+	}
+	return inventoryWorker{}, false
+}
+
+// applyTo overrides the -o options in opts with those set for w.
+func (w inventoryWorker) applyTo(opts sshOptions) sshOptions {
+	if w.User != "" {
+		opts.User = w.User
+	}
+	if w.Port != 0 {
+		opts.Port = strconv.Itoa(w.Port)
+	}
+	return opts
+}
+
+// integrationWorkers returns the workers tagged for integration tests.
+func (inv *inventory) integrationWorkers() []string {
+	var hosts []string
+	for _, w := range inv.Workers {
+		if hasTag(w.Tags, integrationTag) {
+			hosts = append(hosts, w.Host)
+		}
+	}
+	return hosts
+}
+
+// integrationPackages returns the packages tagged for integration tests,
+// directly or through a group.
+func (inv *inventory) integrationPackages() []string {
+	var packages []string
+	for _, p := range inv.Packages {
+		if hasTag(p.Tags, integrationTag) {
+			packages = append(packages, p.Name)
+		}
+	}
+	for _, g := range inv.Groups {
+		if hasTag(g.Tags, integrationTag) {
+			packages = append(packages, g.Packages...)
+		}
+	}
+	return packages
+}
+
+// packageFixtures returns the fixtures needed by each package that needs
+// any, as a fixtureKey.
+func (inv *inventory) packageFixtures() map[string]string {
+	fixtures := make(map[string]string)
+	for _, p := range inv.Packages {
+		if len(p.Fixtures) > 0 {
+			fixtures[p.Name] = fixtureKey(p.Fixtures)
+		}
+	}
+	return fixtures
+}
+
+// workerFixtures returns the fixtures each worker has.
+func (inv *inventory) workerFixtures() map[string]map[string]bool {
+	fixtures := make(map[string]map[string]bool)
+	for _, w := range inv.Workers {
+		fixtures[w.Host] = stringSet(w.Fixtures)
+	}
+	return fixtures
+}
+
+// packageTimeouts returns the go test timeout of each package that has one,
+// its own or else its group's.
+func (inv *inventory) packageTimeouts() map[string]time.Duration {
+	timeouts := make(map[string]time.Duration)
+	for _, g := range inv.Groups {
+		if g.Timeout == "" {
+			continue
+		}
+		timeout, _ := time.ParseDuration(g.Timeout)
+		for _, pkg := range g.Packages {
+			timeouts[pkg] = timeout
+		}
+	}
+	for _, p := range inv.Packages {
+		if p.Timeout != "" {
+			timeouts[p.Name], _ = time.ParseDuration(p.Timeout)
+		}
+	}
+	return timeouts
+}
+
+// aliases returns the groups as package aliases.
+func (inv *inventory) aliases() map[string][]string {
+	aliases := make(map[string][]string)
+	for _, g := range inv.Groups {
+		aliases[g.Name] = g.Packages
+	}
+	return aliases
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sampleInventory = `{
+	"workers": [
+		{"host": "homework1", "user": "ci", "port": 2222, "tags": ["integration"]},
+		{"host": "homework2", "fixtures": ["charm-store", "mongo"]},
+		{"host": "homework3", "repo_path": "/srv/juju", "go_version": "go1.22.1"}
+	],
+	"packages": [
+		{"name": "featuretests", "tags": ["integration"], "timeout": "40m"},
+		{"name": "charmrepo", "fixtures": ["mongo", "charm-store"]},
+		{"name": "state"}
+	],
+	"groups": [
+		{"name": "core", "packages": ["state", "api"], "timeout": "30m"},
+		{"name": "slow", "packages": ["cmd/juju"], "tags": ["integration"]}
+	]
+}`
+
+func TestReadInventory(t *testing.T) {
+	inv, err := readInventory(strings.NewReader(sampleInventory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &inventory{
+		Workers: []inventoryWorker{
+			{Host: "homework1", User: "ci", Port: 2222, Tags: []string{"integration"}},
+			{Host: "homework2", Fixtures: []string{"charm-store", "mongo"}},
+			{Host: "homework3", RepoPath: "/srv/juju", GoVersion: "go1.22.1"},
+		},
+		Packages: []inventoryPackage{
+			{Name: "featuretests", Tags: []string{"integration"}, Timeout: "40m"},
+			{Name: "charmrepo", Fixtures: []string{"mongo", "charm-store"}},
+			{Name: "state"},
+		},
+		Groups: []inventoryGroup{
+			{Name: "core", Packages: []string{"state", "api"}, Timeout: "30m"},
+			{Name: "slow", Packages: []string{"cmd/juju"}, Tags: []string{"integration"}},
+		},
+	}
+	if !reflect.DeepEqual(inv, want) {
+		t.Fatalf("readInventory() =\n%+v\nwant\n%+v", inv, want)
+	}
+
+	checks := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"hosts", inv.hosts(), []string{"homework1", "homework2", "homework3"}},
+		{"packageNames", inv.packageNames(), []string{"featuretests", "charmrepo", "state"}},
+		{"integrationWorkers", inv.integrationWorkers(), []string{"homework1"}},
+		{"integrationPackages", inv.integrationPackages(), []string{"featuretests", "cmd/juju"}},
+		{"packageFixtures", inv.packageFixtures(), map[string]string{"charmrepo": "charm-store,mongo"}},
+		{"workerFixtures", inv.workerFixtures(), map[string]map[string]bool{
+			"homework1": {},
+			"homework2": {"charm-store": true, "mongo": true},
+			"homework3": {},
+		}},
+		{"packageTimeouts", inv.packageTimeouts(), map[string]time.Duration{
+			"featuretests": 40 * time.Minute, "state": 30 * time.Minute, "api": 30 * time.Minute,
+		}},
+		{"aliases", inv.aliases(), map[string][]string{"core": {"state", "api"}, "slow": {"cmd/juju"}}},
+	}
+	for _, check := range checks {
+		if !reflect.DeepEqual(check.got, check.want) {
+			t.Errorf("%s() = %v, want %v", check.name, check.got, check.want)
+		}
+	}
+}
+
+func TestInventoryWorkerApplyTo(t *testing.T) {
+	inv, err := readInventory(strings.NewReader(sampleInventory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := sshOptions{User: "juju", Port: "22", StrictHostKeyChecking: "yes"}
+	tests := []struct {
+		host string
+		want sshOptions
+	}{
+		{"homework1", sshOptions{User: "ci", Port: "2222", StrictHostKeyChecking: "yes"}},
+		{"homework2", options},
+		{"homework9", options},
+	}
+	for _, test := range tests {
+		w, _ := inv.worker(test.host)
+		if got := w.applyTo(options); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: applyTo() = %+v, want %+v", test.host, got, test.want)
+		}
+	}
+	if _, ok := inv.worker("homework9"); ok {
+		t.Errorf("found homework9, which isn't in the inventory")
+	}
+}
+
+func TestReadInventoryErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		inventory string
+		err       string
+	}{
+		{"unknown field", `{"workers": [{"host": "homework1", "hostname": "x"}]}`, `json: unknown field "hostname"`},
+		{"no host", `{"workers": [{"user": "ci"}]}`, "worker 1 has no host"},
+		{"duplicate host", `{"workers": [{"host": "homework1"}, {"host": "homework1"}]}`, "worker homework1 is listed twice"},
+		{"bad port", `{"workers": [{"host": "homework1", "port": 70000}]}`, "worker homework1 has bad port 70000"},
+		{"unknown dialer", `{"workers": [{"host": "homework1", "dialer": "carrier-pigeon"}]}`,
+			`worker homework1: unknown dialer "carrier-pigeon"`},
+		{"no package name", `{"packages": [{"tags": ["integration"]}]}`, "package 1 has no name"},
+		{"duplicate package", `{"packages": [{"name": "state"}, {"name": "state"}]}`, "package state is listed twice"},
+		{"no group name", `{"groups": [{"packages": ["state"]}]}`, "group 1 has no name"},
+		{"duplicate group", `{"groups": [{"name": "core", "packages": ["state"]}, {"name": "core", "packages": ["api"]}]}`,
+			"group core is listed twice"},
+		{"empty group", `{"groups": [{"name": "core"}]}`, "group core has no packages"},
+		{"bad package timeout", `{"packages": [{"name": "state", "timeout": "soon"}]}`, `package state has bad timeout "soon"`},
+		{"bad group timeout", `{"groups": [{"name": "core", "packages": ["state"], "timeout": "-1m"}]}`,
+			`group core has bad timeout "-1m"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := readInventory(strings.NewReader(test.inventory))
+			if err == nil || err.Error() != test.err {
+				t.Errorf("readInventory() error = %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestLoadInventoryNamesTheFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	if err := os.WriteFile(path, []byte(`{"workers": [{}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadInventory(path); err == nil || err.Error() != path+": worker 1 has no host" {
+		t.Errorf("loadInventory() error = %v", err)
+	}
+}
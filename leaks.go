@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// leakWarning matches the lines that goroutine leak checkers print: goleak's
+// "found unexpected goroutines", leaktest's "leaked goroutine" and the more
+// generic "goroutine leak".
+var leakWarning = regexp.MustCompile(`(?i)found unexpected goroutines|leaked goroutine|goroutine leak`)
+
+// findLeakWarnings returns the lines of output that are goroutine leak
+// warnings.
+func findLeakWarnings(output string) []string {
+	var warnings []string
+	for _, line := range strings.Split(output, "\n") {
+		if leakWarning.MatchString(line) {
+			warnings = append(warnings, strings.TrimSpace(line))
+		}
+	}
+	return warnings
+}
+
+// writeLeakSummary writes the goroutine leak warnings from results to w,
+// grouped by package. It writes nothing if there were none.
+func writeLeakSummary(w io.Writer, results []Result) {
+	header := false
+	for _, result := range results {
+		if len(result.LeakWarnings) == 0 {
+			continue
+		}
+		if !header {
+			fmt.Fprintln(w, "goroutine leak warnings:")
+			header = true
+		}
+		fmt.Fprintf(w, "  %s (%d):\n", result.Package, len(result.LeakWarnings))
+		for _, warning := range result.LeakWarnings {
+			fmt.Fprintf(w, "    %s\n", warning)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+const sampleLeakOutput = `=== RUN   TestWatcher
+--- PASS: TestWatcher (0.20s)
+PASS
+goleak: Errors on successful test run: found unexpected goroutines:
+[Goroutine 42 in state chan receive, with github.com/juju/juju/state.(*watcher).loop on top of the stack:
+    leaktest.go:132: leaked goroutine: goroutine 17 [select]:
+Goroutine leak detected in TestModel
+ok  	github.com/juju/juju/state	1.234s
+`
+
+func TestFindLeakWarnings(t *testing.T) {
+	got := findLeakWarnings(sampleLeakOutput)
+	want := []string{
+		"goleak: Errors on successful test run: found unexpected goroutines:",
+		"leaktest.go:132: leaked goroutine: goroutine 17 [select]:",
+		"Goroutine leak detected in TestModel",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findLeakWarnings() = %q, want %q", got, want)
+	}
+	if got := findLeakWarnings("ok  \tgithub.com/juju/juju/api\t0.5s\n"); got != nil {
+		t.Errorf("findLeakWarnings() of clean output = %q", got)
+	}
+}
+
+func TestWriteLeakSummary(t *testing.T) {
+	results := []Result{
+		{Package: "state", LeakWarnings: []string{"found unexpected goroutines:", "leaked goroutine: goroutine 17"}},
+		{Package: "api"},
+		{Package: "worker", LeakWarnings: []string{"goroutine leak in TestRun"}},
+	}
+	var out bytes.Buffer
+	writeLeakSummary(&out, results)
+	want := `goroutine leak warnings:
+  state (2):
+    found unexpected goroutines:
+    leaked goroutine: goroutine 17
+  worker (1):
+    goroutine leak in TestRun
+`
+	if out.String() != want {
+		t.Errorf("writeLeakSummary() =\n%s\nwant\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	writeLeakSummary(&out, []Result{{Package: "api"}})
+	if out.Len() != 0 {
+		t.Errorf("summary without leaks is %q", out.String())
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loadCommand prints the worker's 1 minute load average and how many CPUs it
+// has.
+const loadCommand = "echo $(cut -d' ' -f1 /proc/loadavg) $(nproc)"
+
+// parseLoad returns the load average per CPU from the output of loadCommand.
+func parseLoad(output string) (float64, error) {
+	fields := strings.Fields(output)
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("unexpected output: %q", output)
+	}
+	load, err := strconv.ParseFloat(fields[len(fields)-2], 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected load average: %q", output)
+	}
+	cpus, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil || cpus < 1 {
+		return 0, fmt.Errorf("unexpected number of CPUs: %q", output)
+	}
+	return load / float64(cpus), nil
+}
+
+// load samples the worker's load average per CPU.
+func (r *RemoteWorker) load() (float64, error) {
+	output, status := r.runCommand(loadCommand)
+	if status != 0 {
+		return 0, fmt.Errorf("exit status %d: %s", status, strings.TrimSpace(output))
+	}
+	return parseLoad(output)
+}
+
+// waitForLoad holds the worker back from taking another package while its
+// load average per CPU is above RemoteWorker.maxLoad, sampling it again
+// every RemoteWorker.loadBackoff. It stops waiting once queue is empty,
+// so a busy worker doesn't hold up the end of the run, and a worker whose
+// load can't be read isn't held back.
+func (r *RemoteWorker) waitForLoad(queue chan string) {
+	if r.maxLoad <= 0 {
+		return
+	}
+	paused := false
+	for len(queue) > 0 {
+		load, err := r.load()
+		if err != nil {
+			log.Printf("unable to read load on %s: %s", r.host, err)
+			return
+		}
+		if load <= r.maxLoad {
+			if paused {
+				log.Printf("resuming %s: load %.2f per CPU", r.host, load)
+			}
+			return
+		}
+		if !paused {
+			log.Printf("pausing %s: load %.2f per CPU is above -max-load %.2f", r.host, load, r.maxLoad)
+			paused = true
+		}
+		select {
+		case <-r.stop:
+			return
+		case <-time.After(r.loadBackoff):
+		}
+	}
+}
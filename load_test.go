@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLoad(t *testing.T) {
+	tests := []struct {
+		output string
+		want   float64
+		err    string
+	}{
+		{"6.00 4\n", 1.5, ""},
+		{"echo $(cut -d' ' -f1 /proc/loadavg) $(nproc)\n0.50 2\n", 0.25, ""},
+		{"4\n", 0, `unexpected output: "4\n"`},
+		{"high 4", 0, `unexpected load average: "high 4"`},
+		{"6.00 0", 0, `unexpected number of CPUs: "6.00 0"`},
+		{"6.00 four", 0, `unexpected number of CPUs: "6.00 four"`},
+	}
+	for _, test := range tests {
+		got, err := parseLoad(test.output)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("parseLoad(%q) error = %v, want %q", test.output, err, test.err)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("parseLoad(%q) = %g, %v, want %g", test.output, got, err, test.want)
+		}
+	}
+}
+
+// loadShell returns a fakeShell whose load average per CPU is each of loads
+// in turn, staying at the last.
+func loadShell(loads ...string) *fakeShell {
+	return newFakeShell(func(command string) (string, int) {
+		if command != loadCommand {
+			return "", 127
+		}
+		load := loads[0]
+		if len(loads) > 1 {
+			loads = loads[1:]
+		}
+		return load + " 1\n", 0
+	})
+}
+
+func TestWaitForLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		loads   []string
+		queued  int
+		samples int
+	}{
+		{"not busy", []string{"0.50"}, 1, 1},
+		{"busy then not", []string{"3.00", "2.50", "0.80"}, 1, 3},
+		{"queue empty", []string{"3.00"}, 0, 0},
+		{"unreadable", []string{"high"}, 1, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			shell := loadShell(test.loads...)
+			r := newFakeWorker(t, shell)
+			r.maxLoad = 1
+			r.loadBackoff = time.Millisecond
+			queue := make(chan string, 1)
+			for i := 0; i < test.queued; i++ {
+				queue <- "state"
+			}
+			r.waitForLoad(queue)
+			if got := len(shell.Commands()); got != test.samples {
+				t.Errorf("sampled the load %d times, want %d", got, test.samples)
+			}
+		})
+	}
+}
+
+func TestWaitForLoadStops(t *testing.T) {
+	r := newFakeWorker(t, loadShell("3.00"))
+	r.maxLoad = 1
+	r.loadBackoff = time.Hour
+	stop := make(chan struct{})
+	close(stop)
+	r.stop = stop
+	queue := make(chan string, 1)
+	queue <- "state"
+	done := make(chan struct{})
+	go func() {
+		r.waitForLoad(queue)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("waitForLoad() didn't return when the worker stopped")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// runRetriedEnv is set in the environment of a run that is itself a retry
+// after a mass failure, so that it isn't retried again.
+const runRetriedEnv = "TESTFARM_RUN_RETRIED"
+
+// massFailure reports whether more than threshold, a fraction, of total
+// workers failed. A threshold of zero or less never counts as one.
+func massFailure(failed, total int, threshold float64) bool {
+	return threshold > 0 && total > 0 && float64(failed) > threshold*float64(total)
+}
+
+// retried reports whether this run is a retry after a mass failure.
+func retried() bool {
+	return os.Getenv(runRetriedEnv) != ""
+}
+
+// retryRun calls stop, if it isn't nil, waits for backoff and then replaces
+// this process with a fresh run using the same arguments. It only returns if
+// the run can't be retried, and then stop isn't called. That is when this run
+// is already a retry, or when stdinPackages is set because the package list
+// was read from stdin, which would be empty for the retry.
+func retryRun(backoff time.Duration, stdinPackages bool, stop func()) error {
+	if retried() {
+		return errors.New("the run has already been retried once")
+	}
+	if stdinPackages {
+		return errors.New("the package list was read from stdin, which can't be read again")
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if stop != nil {
+		stop()
+	}
+	log.Printf("retrying the whole run in %s", backoff)
+	time.Sleep(backoff)
+	// An empty runRetriedEnv already in the environment would be found
+	// first, so it is replaced rather than added to.
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, runRetriedEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	return syscall.Exec(executable, os.Args, append(env, runRetriedEnv+"=1"))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestMassFailure(t *testing.T) {
+	tests := []struct {
+		failed    int
+		total     int
+		threshold float64
+		want      bool
+	}{
+		{3, 4, 0.5, true},
+		{2, 4, 0.5, false},
+		{1, 1, 0.5, true},
+		{4, 4, 0, false},
+		{4, 4, -1, false},
+		{0, 0, 0.5, false},
+	}
+	for _, test := range tests {
+		if got := massFailure(test.failed, test.total, test.threshold); got != test.want {
+			t.Errorf("massFailure(%d, %d, %g) = %t, want %t", test.failed, test.total, test.threshold, got, test.want)
+		}
+	}
+}
+
+// retryRunHelperEnv is set when TestRetryRun runs itself, as the run that
+// retryRun replaces.
+const retryRunHelperEnv = "TESTFARM_RETRY_RUN_HELPER"
+
+func TestRetryRun(t *testing.T) {
+	if os.Getenv(retryRunHelperEnv) != "" {
+		// The first run stops and is replaced by the retry, which is
+		// refused a second retry and reports it.
+		err := retryRun(0, false, func() { fmt.Println("stopped") })
+		fmt.Printf("%s=%s: %v\n", runRetriedEnv, os.Getenv(runRetriedEnv), err)
+		os.Exit(0)
+	}
+	// An empty runRetriedEnv doesn't count as a retry.
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRetryRun$")
+	cmd.Env = append(os.Environ(), retryRunHelperEnv+"=1", runRetriedEnv+"=")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	want := "stopped\n" + runRetriedEnv + "=1: the run has already been retried once\n"
+	if string(out) != want {
+		t.Errorf("the run printed %q, want %q", out, want)
+	}
+}
+
+func TestRetryRunRefusesStdinPackages(t *testing.T) {
+	t.Setenv(runRetriedEnv, "")
+	stopped := false
+	err := retryRun(0, true, func() { stopped = true })
+	if err == nil || err.Error() != "the package list was read from stdin, which can't be read again" || stopped {
+		t.Errorf("retryRun() = %v, stopped %t", err, stopped)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// retainedOutput keeps a bounded amount of a package's output as it is read.
+// Until a failure shows up it is a ring buffer of the last tailLines lines.
+// From the first FAIL or panic line it keeps everything, up to maxLines,
+// along with the lines in the ring buffer, since a timeout or panic reports
+// what went wrong before the FAIL line that ends it. With tailLines 0 it
+// keeps everything.
+//
+// What runPackage needs to know from the output is gathered line by line, so
+// that it doesn't matter which lines are dropped.
+type retainedOutput struct {
+	tailLines int
+	maxLines  int
+	ring      []string
+	next      int
+	full      []string
+	capturing bool
+	// dropped is the number of lines dropped from the ring buffer and more
+	// the number dropped once maxLines were kept.
+	dropped int
+	more    int
+	// limit is how long full may grow, once maxLines is applied.
+	limit int
+
+	failed bool
+	killed bool
+	skips  []string
+	leaks  []string
+}
+
+func newRetainedOutput(tailLines, maxLines int) *retainedOutput {
+	return &retainedOutput{tailLines: tailLines, maxLines: maxLines, capturing: tailLines <= 0, limit: maxLines}
+}
+
+// addOutput records the rest of the output, which may not end in a newline.
+func (o *retainedOutput) addOutput(output string) {
+	for _, line := range strings.SplitAfter(output, "\n") {
+		if line != "" {
+			o.addLine(line)
+		}
+	}
+}
+
+// addLine records the next line of output.
+func (o *retainedOutput) addLine(line string) {
+	failure := failLine.MatchString(line)
+	o.failed = o.failed || failure
+	o.killed = o.killed || killedBySignal.MatchString(line)
+	o.skips = append(o.skips, findSkips(line)...)
+	o.leaks = append(o.leaks, findLeakWarnings(line)...)
+
+	if !o.capturing && (failure || strings.HasPrefix(line, "panic: ")) {
+		o.full = o.tail()
+		o.ring = nil
+		o.capturing = true
+		o.limit = len(o.full) + o.maxLines
+	}
+	if o.capturing {
+		if o.maxLines > 0 && len(o.full) >= o.limit {
+			o.more++
+			return
+		}
+		o.full = append(o.full, line)
+		return
+	}
+
+	if len(o.ring) < o.tailLines {
+		o.ring = append(o.ring, line)
+		return
+	}
+	o.dropped++
+	o.ring[o.next] = line
+	o.next = (o.next + 1) % len(o.ring)
+}
+
+// tail returns the lines in the ring buffer, oldest first.
+func (o *retainedOutput) tail() []string {
+	lines := make([]string, 0, len(o.ring))
+	lines = append(lines, o.ring[o.next:]...)
+	return append(lines, o.ring[:o.next]...)
+}
+
+// String returns the retained output, noting how many lines were dropped
+// from before the ring buffer and after maxLines.
+func (o *retainedOutput) String() string {
+	lines := o.full
+	if !o.capturing {
+		lines = o.tail()
+	}
+	var out strings.Builder
+	if o.dropped > 0 {
+		fmt.Fprintf(&out, "[%d lines of output not kept]\n", o.dropped)
+	}
+	for _, line := range lines {
+		out.WriteString(line)
+	}
+	if o.more > 0 {
+		if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+			out.WriteString("\n")
+		}
+		fmt.Fprintf(&out, "[%d more lines of output not kept]\n", o.more)
+	}
+	return out.String()
+}
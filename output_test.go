@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// numberedLines returns lines "<prefix> 1\n" to "<prefix> n\n".
+func numberedLines(prefix string, n int) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, "%s %d\n", prefix, i)
+	}
+	return b.String()
+}
+
+// timeoutOutput is what go test prints when a test binary times out: the
+// panic saying why, then a stack for every goroutine, then FAIL.
+var timeoutOutput = "panic: test timed out after 20m0s\n" +
+	"running tests:\n\tTestWatcher (20m0s)\n\n" +
+	numberedLines("goroutine", 50) +
+	"FAIL\tgithub.com/juju/juju/state\t1200.012s\n"
+
+func TestRetainedOutput(t *testing.T) {
+	tests := []struct {
+		name      string
+		output    string
+		tailLines int
+		maxLines  int
+		want      string
+	}{{
+		name:      "pass within the tail",
+		output:    "ok\tgithub.com/juju/juju/state\t1.2s\n",
+		tailLines: 3,
+		want:      "ok\tgithub.com/juju/juju/state\t1.2s\n",
+	}, {
+		name:      "pass keeps the tail",
+		output:    numberedLines("line", 5),
+		tailLines: 2,
+		want:      "[3 lines of output not kept]\nline 4\nline 5\n",
+	}, {
+		name:      "pass without a final newline",
+		output:    "line 1\nline 2\nok",
+		tailLines: 2,
+		want:      "[1 lines of output not kept]\nline 2\nok",
+	}, {
+		name:      "everything",
+		output:    numberedLines("line", 100),
+		tailLines: 0,
+		want:      numberedLines("line", 100),
+	}, {
+		name:      "fail keeps the tail before the failure",
+		output:    numberedLines("line", 5) + "--- FAIL: TestWatcher (0.01s)\n" + numberedLines("after", 3),
+		tailLines: 2,
+		maxLines:  10,
+		want:      "[3 lines of output not kept]\nline 4\nline 5\n--- FAIL: TestWatcher (0.01s)\n" + numberedLines("after", 3),
+	}, {
+		name:      "fail keeps up to the limit",
+		output:    numberedLines("line", 5) + "--- FAIL: TestWatcher (0.01s)\n" + numberedLines("after", 3),
+		tailLines: 2,
+		maxLines:  2,
+		want:      "[3 lines of output not kept]\nline 4\nline 5\n--- FAIL: TestWatcher (0.01s)\nafter 1\n[2 more lines of output not kept]\n",
+	}, {
+		name:      "fail without a limit",
+		output:    "FAIL\n" + numberedLines("line", 100),
+		tailLines: 2,
+		want:      "FAIL\n" + numberedLines("line", 100),
+	}, {
+		name:      "timeout keeps the panic",
+		output:    timeoutOutput,
+		tailLines: 5,
+		maxLines:  4,
+		want:      "panic: test timed out after 20m0s\nrunning tests:\n\tTestWatcher (20m0s)\n\n[51 more lines of output not kept]\n",
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			retained := newRetainedOutput(test.tailLines, test.maxLines)
+			retained.addOutput(test.output)
+			if got := retained.String(); got != test.want {
+				t.Errorf("retained %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRetainedOutputFindsDroppedLines(t *testing.T) {
+	retained := newRetainedOutput(1, 1)
+	retained.addOutput("--- SKIP: TestLogin (0.00s)\n" +
+		"found unexpected goroutines:\n" +
+		"panic: runtime error\n" +
+		"signal: killed\n" +
+		"FAIL\tgithub.com/juju/juju/state\t1.2s\n")
+	if got, want := retained.String(), "[1 lines of output not kept]\nfound unexpected goroutines:\npanic: runtime error\n[2 more lines of output not kept]\n"; got != want {
+		t.Errorf("retained %q, want %q", got, want)
+	}
+	if !retained.failed || !retained.killed {
+		t.Errorf("failed %t and killed %t, want both", retained.failed, retained.killed)
+	}
+	if len(retained.skips) != 1 || len(retained.leaks) != 1 {
+		t.Errorf("found skips %q and leak warnings %q, want one of each", retained.skips, retained.leaks)
+	}
+}
+
+func TestRunPackageRetainsOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		status  int
+		keep    string
+		notKept string
+		passed  bool
+	}{
+		{"pass", numberedLines("ok", 20), 0, "ok 20\n", "ok 1\n", true},
+		// The FAIL line isn't kept, but the package still failed.
+		{"timeout", "=== RUN   TestWatcher\n" + timeoutOutput, 0, "panic: test timed out", "FAIL\tgithub.com", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			shell := newFakeShell(func(command string) (string, int) {
+				if strings.HasPrefix(command, "go test") {
+					return test.output, test.status
+				}
+				return "", 0
+			})
+			r := newFakeWorker(t, shell)
+			r.tailLines = 5
+			r.maxOutputLines = 10
+			result := r.runPackage("state")
+			if !strings.Contains(result.Output, test.keep) {
+				t.Errorf("output %q doesn't have %q", result.Output, test.keep)
+			}
+			if strings.Contains(result.Output, test.notKept) {
+				t.Errorf("output %q has %q", result.Output, test.notKept)
+			}
+			if result.Passed != test.passed {
+				t.Errorf("passed = %t, want %t", result.Passed, test.passed)
+			}
+		})
+	}
+}
+
+func TestReadUntilRetainsLines(t *testing.T) {
+	for _, sentinels := range []bool{false, true} {
+		r := newFakeWorker(t, newFakeShell(func(string) (string, int) { return numberedLines("line", 1000), 0 }))
+		r.sentinels = sentinels
+		r.retained = newRetainedOutput(2, 0)
+		output, status := r.runCommand("go test ./...")
+		// Everything but the status line went to retained.
+		if output != "" || status != 0 {
+			t.Errorf("sentinels %t: runCommand() = %q, %d", sentinels, output, status)
+		}
+		if got, want := r.retained.String(), "[998 lines of output not kept]\nline 999\nline 1000\n"; got != want {
+			t.Errorf("sentinels %t: retained %q, want %q", sentinels, got, want)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// redacted replaces whatever a -redact pattern matches.
+const redacted = "[REDACTED]"
+
+// redactor removes secrets from output before it is printed, reported or
+// written to a transcript.
+type redactor []*regexp.Regexp
+
+// parseRedactions compiles the -redact patterns.
+func parseRedactions(patterns []string) (redactor, error) {
+	var r redactor
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %s", pattern, err)
+		}
+		r = append(r, re)
+	}
+	return r, nil
+}
+
+// Redact returns s with every match of each pattern replaced by redacted.
+func (r redactor) Redact(s string) string {
+	for _, re := range r {
+		s = re.ReplaceAllString(s, redacted)
+	}
+	return s
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// remoteEnv is what we know about a worker's environment, for reproducing
+// its results.
+type remoteEnv struct {
+	GoEnv     map[string]string
+	OSRelease map[string]string
+	// Tools maps a version command to what it printed.
+	Tools map[string]string
+}
+
+// toolVersionCommands are run on each worker to find out the versions of the
+// tools that matter to a test run.
+var toolVersionCommands = []string{"go version", "git --version", "uname -srm"}
+
+// reportedGoEnv are the go env variables included in the report. The rest
+// rarely explain a difference between workers.
+var reportedGoEnv = []string{
+	"GOVERSION", "GOOS", "GOARCH", "GOROOT", "GOPATH", "GOFLAGS",
+	"GO111MODULE", "GOPROXY", "CGO_ENABLED",
+}
+
+// captureEnv collects the worker's go env, OS release and tool versions.
+func (r *RemoteWorker) captureEnv() (*remoteEnv, error) {
+	env := &remoteEnv{Tools: make(map[string]string)}
+	output, status := r.runCommand("go env")
+	if status != 0 {
+		return nil, fmt.Errorf("go env failed with status %d: %s", status, strings.TrimSpace(output))
+	}
+	env.GoEnv = parseAssignments(strings.NewReader(output))
+
+	output, _ = r.runCommand("cat /etc/os-release")
+	env.OSRelease = parseAssignments(strings.NewReader(output))
+
+	for _, command := range toolVersionCommands {
+		output, status := r.runCommand(command)
+		if status == 0 {
+			env.Tools[command] = lastLine(output)
+		}
+	}
+	return env, nil
+}
+
+// lastLine returns the last non-blank line of output, which for a command
+// that prints one line is that line rather than the shell's echo of the
+// command.
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// parseAssignments parses shell variable assignments, one per line, as
+// printed by go env and found in /etc/os-release. Values may be single or
+// double quoted. Lines that aren't assignments are ignored.
+func parseAssignments(r io.Reader) map[string]string {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		line = strings.TrimPrefix(line, "export ")
+		name, value, ok := strings.Cut(line, "=")
+		if !ok || name == "" || strings.ContainsAny(name, " \t#") {
+			continue
+		}
+		switch {
+		case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+			value = value[1 : len(value)-1]
+		case len(value) >= 2 && value[0] == '"':
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			}
+		}
+		values[name] = value
+	}
+	return values
+}
+
+// writeEnvReport writes the environment of each worker in envs to w, in
+// order of host name.
+func writeEnvReport(w io.Writer, envs map[string]*remoteEnv) {
+	var hosts []string
+	for host := range envs {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		env := envs[host]
+		fmt.Fprintf(w, "environment of %s:\n", host)
+		if name := env.OSRelease["PRETTY_NAME"]; name != "" {
+			fmt.Fprintf(w, "  os: %s\n", name)
+		}
+		for _, command := range toolVersionCommands {
+			if version, ok := env.Tools[command]; ok {
+				fmt.Fprintf(w, "  %s: %s\n", command, version)
+			}
+		}
+		for _, name := range reportedGoEnv {
+			if value, ok := env.GoEnv[name]; ok {
+				fmt.Fprintf(w, "  %s=%s\n", name, value)
+			}
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const sampleGoEnv = `GO111MODULE=''
+GOARCH='amd64'
+GOFLAGS='-mod=mod'
+GOOS='linux'
+GOPATH='/home/ci/go'
+GOPROXY='https://proxy.golang.org,direct'
+GOROOT='/usr/local/go'
+GOVERSION='go1.22.1'
+CGO_ENABLED='1'
+`
+
+const sampleOSRelease = `PRETTY_NAME="Ubuntu 22.04.4 LTS"
+NAME="Ubuntu"
+VERSION_ID="22.04"
+# a comment
+ID=ubuntu
+HOME_URL="https://www.ubuntu.com/"
+`
+
+func TestParseAssignments(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]string
+	}{{
+		name:  "go env",
+		input: sampleGoEnv,
+		want: map[string]string{
+			"GO111MODULE": "", "GOARCH": "amd64", "GOFLAGS": "-mod=mod", "GOOS": "linux",
+			"GOPATH": "/home/ci/go", "GOPROXY": "https://proxy.golang.org,direct",
+			"GOROOT": "/usr/local/go", "GOVERSION": "go1.22.1", "CGO_ENABLED": "1",
+		},
+	}, {
+		name:  "os-release",
+		input: sampleOSRelease,
+		want: map[string]string{
+			"PRETTY_NAME": "Ubuntu 22.04.4 LTS", "NAME": "Ubuntu", "VERSION_ID": "22.04",
+			"ID": "ubuntu", "HOME_URL": "https://www.ubuntu.com/",
+		},
+	}, {
+		name:  "old go env",
+		input: "export GOOS=\"linux\"\nGOFLAGS=\"-tags=\\\"x y\\\"\"\ngo env\nnot an assignment\n#GOOS=plan9\n",
+		want:  map[string]string{"GOOS": "linux", "GOFLAGS": `-tags="x y"`},
+	}}
+	for _, test := range tests {
+		if got := parseAssignments(strings.NewReader(test.input)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: parseAssignments() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLastLine(t *testing.T) {
+	tests := []struct {
+		output string
+		want   string
+	}{
+		{"go version go1.22.1 linux/amd64\n", "go version go1.22.1 linux/amd64"},
+		{"uname -srm\nLinux 6.5.0 x86_64\n\n", "Linux 6.5.0 x86_64"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := lastLine(test.output); got != test.want {
+			t.Errorf("lastLine(%q) = %q, want %q", test.output, got, test.want)
+		}
+	}
+}
+
+// envShell returns a fakeShell answering the commands run by captureEnv, in
+// which git isn't installed.
+func envShell() *fakeShell {
+	return newFakeShell(func(command string) (string, int) {
+		switch command {
+		case "go env":
+			return sampleGoEnv, 0
+		case "cat /etc/os-release":
+			return sampleOSRelease, 0
+		case "go version":
+			return "go version go1.22.1 linux/amd64\n", 0
+		case "uname -srm":
+			return "Linux 6.5.0-26-generic x86_64\n", 0
+		}
+		return "sh: 1: " + strings.Fields(command)[0] + ": not found\n", 127
+	})
+}
+
+func TestCaptureEnv(t *testing.T) {
+	r := newFakeWorker(t, envShell())
+	env, err := r.captureEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.GoEnv["GOVERSION"] != "go1.22.1" || env.OSRelease["PRETTY_NAME"] != "Ubuntu 22.04.4 LTS" {
+		t.Errorf("captured go env %v and os-release %v", env.GoEnv, env.OSRelease)
+	}
+	want := map[string]string{
+		"go version": "go version go1.22.1 linux/amd64",
+		"uname -srm": "Linux 6.5.0-26-generic x86_64",
+	}
+	if !reflect.DeepEqual(env.Tools, want) {
+		t.Errorf("captured tools %v, want %v", env.Tools, want)
+	}
+}
+
+func TestCaptureEnvGoEnvFails(t *testing.T) {
+	r := newFakeWorker(t, newFakeShell(func(string) (string, int) { return "sh: 1: go: not found\n", 127 }))
+	if _, err := r.captureEnv(); err == nil || err.Error() != "go env failed with status 127: sh: 1: go: not found" {
+		t.Errorf("captureEnv() error = %v", err)
+	}
+}
+
+func TestWriteEnvReport(t *testing.T) {
+	envs := map[string]*remoteEnv{
+		"homework2": {
+			GoEnv: map[string]string{"GOVERSION": "go1.21.0", "GOOS": "linux", "GOEXPERIMENT": ""},
+			Tools: map[string]string{"go version": "go version go1.21.0 linux/arm64"},
+		},
+		"homework1": {
+			GoEnv:     parseAssignments(strings.NewReader(sampleGoEnv)),
+			OSRelease: parseAssignments(strings.NewReader(sampleOSRelease)),
+			Tools:     map[string]string{"uname -srm": "Linux 6.5.0 x86_64", "go version": "go version go1.22.1 linux/amd64"},
+		},
+	}
+	want := `environment of homework1:
+  os: Ubuntu 22.04.4 LTS
+  go version: go version go1.22.1 linux/amd64
+  uname -srm: Linux 6.5.0 x86_64
+  GOVERSION=go1.22.1
+  GOOS=linux
+  GOARCH=amd64
+  GOROOT=/usr/local/go
+  GOPATH=/home/ci/go
+  GOFLAGS=-mod=mod
+  GO111MODULE=
+  GOPROXY=https://proxy.golang.org,direct
+  CGO_ENABLED=1
+environment of homework2:
+  go version: go version go1.21.0 linux/arm64
+  GOVERSION=go1.21.0
+  GOOS=linux
+`
+	var buf bytes.Buffer
+	writeEnvReport(&buf, envs)
+	if buf.String() != want {
+		t.Errorf("writeEnvReport() wrote\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestFarmKeepsEnvOfExcludedWorker(t *testing.T) {
+	good := startFakeServer(t, "127.0.0.2", envShell())
+	bad := startFakeServer(t, "127.0.0.3", envShell())
+	f := newFakeFarm(t, nil, good, bad)
+	f.warmup = func(w *RemoteWorker) error {
+		env, err := w.captureEnv()
+		if err != nil {
+			return err
+		}
+		w.env = env
+		if w.host == bad.host {
+			return errors.New("smoke test of state failed")
+		}
+		return nil
+	}
+
+	if err := f.AddWorker(good.host); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.AddWorker(bad.host); err == nil {
+		t.Fatalf("AddWorker(%s) succeeded", bad.host)
+	}
+	envs := f.Envs()
+	if len(envs) != 2 || envs[good.host] == nil || envs[bad.host] == nil {
+		t.Errorf("kept environments %v, want both workers'", envs)
+	}
+	if len(f.Workers()) != 1 {
+		t.Errorf("workers are %v, want only %s", f.Workers(), good.host)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// resultLine is the machine readable form of a Result written by -results-fd,
+// one JSON object per line.
+type resultLine struct {
+	Package         string   `json:"package"`
+	Worker          string   `json:"worker"`
+	Status          string   `json:"status"`
+	ExitCode        int      `json:"exit_code"`
+	Attempts        int      `json:"attempts"`
+	DurationSeconds float64  `json:"duration_seconds"`
+	OOMKilled       bool     `json:"oom_killed,omitempty"`
+	LeakWarnings    []string `json:"leak_warnings,omitempty"`
+	Skipped         []string `json:"skipped,omitempty"`
+	FlakyTest       string   `json:"flaky_test,omitempty"`
+	Commit          string   `json:"commit,omitempty"`
+}
+
+// writeResultLine writes result to w as a single line of JSON, labelled with
+// the local commit if there is one.
+func writeResultLine(w io.Writer, result Result, commit string) error {
+	return json.NewEncoder(w).Encode(resultLine{
+		Package:         result.Package,
+		Worker:          result.Worker,
+		Status:          result.Status(),
+		ExitCode:        result.ExitCode,
+		Attempts:        result.Attempts,
+		DurationSeconds: result.Duration.Seconds(),
+		OOMKilled:       result.OOMKilled,
+		LeakWarnings:    result.LeakWarnings,
+		Skipped:         result.Skipped,
+		FlakyTest:       result.FlakyTest,
+		Commit:          commit,
+	})
+}
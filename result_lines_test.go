@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWriteResultLineToPipe(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	results := []Result{
+		{Package: "state", Worker: "homework1", Passed: true, Attempts: 1, Duration: 1500 * time.Millisecond},
+		{Package: "api", Worker: "homework2", ExitCode: 2, Attempts: 2, Duration: time.Second,
+			OOMKilled: true, LeakWarnings: []string{"found unexpected goroutines"},
+			Skipped: []string{"TestLogin"}, FlakyTest: "TestLogin/bad_password"},
+		{Package: "cmd", Worker: "homework2", Cancelled: true},
+	}
+	go func() {
+		for _, result := range results {
+			if err := writeResultLine(w, result, "0123abcd"); err != nil {
+				t.Error(err)
+			}
+		}
+		w.Close()
+	}()
+
+	var got []resultLine
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var line resultLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("%q: %s", scanner.Text(), err)
+		}
+		got = append(got, line)
+	}
+	want := []resultLine{
+		{Package: "state", Worker: "homework1", Status: "pass", Attempts: 1, DurationSeconds: 1.5, Commit: "0123abcd"},
+		{Package: "api", Worker: "homework2", Status: "fail", ExitCode: 2, Attempts: 2, DurationSeconds: 1,
+			OOMKilled: true, LeakWarnings: []string{"found unexpected goroutines"},
+			Skipped: []string{"TestLogin"}, FlakyTest: "TestLogin/bad_password", Commit: "0123abcd"},
+		{Package: "cmd", Worker: "homework2", Status: "cancelled", Commit: "0123abcd"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("read back\n%+v\nwant\n%+v", got, want)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// packageType says what kind of tests a package holds, which decides which
+// workers may run it.
+type packageType int
+
+const (
+	// unitPackage holds unit tests, which can run on any worker.
+	unitPackage packageType = iota
+	// integrationPackage holds integration tests, which only run on the
+	// workers listed in -integration-workers.
+	integrationPackage
+)
+
+// classifyPackage returns the type of pkg. Packages listed in integration are
+// integration packages, as is anything named featuretests or with
+// "integration" in its final path element. Everything else is a unit package.
+func classifyPackage(pkg string, integration map[string]bool) packageType {
+	name := path.Base(pkg)
+	if integration[pkg] || name == "featuretests" || strings.Contains(name, "integration") {
+		return integrationPackage
+	}
+	return unitPackage
+}
+
+// commaList splits a comma separated flag value, dropping empty entries.
+func commaList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// stringSet returns a set holding each of items.
+func stringSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[item] = true
+	}
+	return set
+}
+
+// fixtureKey returns the comma separated, sorted list of fixtures, which
+// identifies the queue for packages that need them.
+func fixtureKey(fixtures []string) string {
+	set := stringSet(fixtures)
+	sorted := make([]string, 0, len(set))
+	for fixture := range set {
+		sorted = append(sorted, fixture)
+	}
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+// hasFixtures reports whether have holds every fixture in key, as returned by
+// fixtureKey.
+func hasFixtures(have map[string]bool, key string) bool {
+	for _, fixture := range commaList(key) {
+		if !have[fixture] {
+			return false
+		}
+	}
+	return true
+}
+
+// parsePins parses -pin definitions of the form worker=package[,package...]
+// into a map from each package to the worker it is pinned to. A package can
+// be an @name from aliases.
+func parsePins(definitions []string, aliases map[string][]string) (map[string]string, error) {
+	pins := make(map[string]string)
+	for _, definition := range definitions {
+		worker, packages, ok := strings.Cut(definition, "=")
+		worker = strings.TrimSpace(worker)
+		if !ok || worker == "" || len(commaList(packages)) == 0 {
+			return nil, fmt.Errorf("pin %q is not of the form worker=package[,package...]", definition)
+		}
+		expanded, err := expandAliases(commaList(packages), aliases)
+		if err != nil {
+			return nil, err
+		}
+		for _, pkg := range expanded {
+			if other, ok := pins[pkg]; ok && other != worker {
+				return nil, fmt.Errorf("%s is pinned to both %s and %s", pkg, other, worker)
+			}
+			pins[pkg] = worker
+		}
+	}
+	return pins, nil
+}
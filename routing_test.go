@@ -0,0 +1,180 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClassifyPackage(t *testing.T) {
+	integration := stringSet([]string{"cmd/juju/bootstrap"})
+	tests := []struct {
+		pkg  string
+		want packageType
+	}{
+		{"state", unitPackage},
+		{"featuretests", integrationPackage},
+		{"cmd/featuretests", integrationPackage},
+		{"apiserver/integration", integrationPackage},
+		{"provider/ec2/integrationtests", integrationPackage},
+		{"integration/state", unitPackage},
+		{"cmd/juju/bootstrap", integrationPackage},
+		{"cmd/juju", unitPackage},
+	}
+	for _, test := range tests {
+		if got := classifyPackage(test.pkg, integration); got != test.want {
+			t.Errorf("classifyPackage(%q) = %d, want %d", test.pkg, got, test.want)
+		}
+	}
+}
+
+func TestCanRunIntegration(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers []string
+		host    string
+		want    bool
+	}{
+		{"any worker when none given", nil, "homework1", true},
+		{"listed", []string{"homework1", "homework4"}, "homework4", true},
+		{"not listed", []string{"homework1"}, "homework2", false},
+	}
+	for _, test := range tests {
+		f := &farm{integrationWorkers: stringSet(test.workers)}
+		if got := f.canRunIntegration(test.host); got != test.want {
+			t.Errorf("%s: canRunIntegration(%q) = %t, want %t", test.name, test.host, got, test.want)
+		}
+	}
+}
+
+func TestCommaList(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", nil},
+		{"homework1", []string{"homework1"}},
+		{" homework1, ,homework2,", []string{"homework1", "homework2"}},
+	}
+	for _, test := range tests {
+		if got := commaList(test.value); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("commaList(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestParsePins(t *testing.T) {
+	tests := []struct {
+		name        string
+		definitions []string
+		want        map[string]string
+		err         string
+	}{{
+		name:        "pins",
+		definitions: []string{"homework1=state,api", " homework2 = cmd "},
+		want:        map[string]string{"state": "homework1", "api": "homework1", "cmd": "homework2"},
+	}, {
+		name:        "same worker twice",
+		definitions: []string{"homework1=state", "homework1=state,api"},
+		want:        map[string]string{"state": "homework1", "api": "homework1"},
+	}, {
+		name:        "two workers",
+		definitions: []string{"homework1=state", "homework2=state"},
+		err:         "state is pinned to both homework1 and homework2",
+	}, {
+		name:        "alias",
+		definitions: []string{"homework1=@core,cmd"},
+		want:        map[string]string{"state": "homework1", "api": "homework1", "cmd": "homework1"},
+	}, {
+		name:        "unknown alias",
+		definitions: []string{"homework1=@edge"},
+		err:         `unknown package alias "@edge"`,
+	}, {
+		name:        "no packages",
+		definitions: []string{"homework1="},
+		err:         `pin "homework1=" is not of the form worker=package[,package...]`,
+	}, {
+		name:        "no worker",
+		definitions: []string{"state"},
+		err:         `pin "state" is not of the form worker=package[,package...]`,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parsePins(test.definitions, map[string][]string{"core": {"state", "api"}})
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("parsePins() error = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parsePins() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFixtureKey(t *testing.T) {
+	tests := []struct {
+		fixtures []string
+		want     string
+	}{
+		{nil, ""},
+		{[]string{"mongo"}, "mongo"},
+		{[]string{"mongo", "charm-store", "mongo"}, "charm-store,mongo"},
+	}
+	for _, test := range tests {
+		if got := fixtureKey(test.fixtures); got != test.want {
+			t.Errorf("fixtureKey(%q) = %q, want %q", test.fixtures, got, test.want)
+		}
+	}
+}
+
+func TestHasFixtures(t *testing.T) {
+	have := map[string]bool{"mongo": true, "charm-store": true}
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"mongo", true},
+		{"charm-store,mongo", true},
+		{"lxd,mongo", false},
+		{"", true},
+	}
+	for _, test := range tests {
+		if got := hasFixtures(have, test.key); got != test.want {
+			t.Errorf("hasFixtures(%q) = %t, want %t", test.key, got, test.want)
+		}
+	}
+	if hasFixtures(nil, "mongo") {
+		t.Errorf("a worker without fixtures has mongo")
+	}
+}
+
+func TestFixtureQueuesFor(t *testing.T) {
+	mongo := make(chan string)
+	both := make(chan string)
+	lxd := make(chan string)
+	f := &farm{
+		fixtureQueues: map[string]chan string{"mongo": mongo, "charm-store,mongo": both, "lxd": lxd},
+		workerFixtures: map[string]map[string]bool{
+			"homework1": {"mongo": true, "charm-store": true},
+			"homework2": {"mongo": true},
+		},
+	}
+	tests := []struct {
+		host string
+		want []chan string
+	}{
+		{"homework1", []chan string{both, mongo}},
+		{"homework2", []chan string{mongo}},
+		{"homework3", nil},
+	}
+	for _, test := range tests {
+		if got := f.fixtureQueuesFor(test.host); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("fixtureQueuesFor(%q) = %v, want %v", test.host, got, test.want)
+		}
+	}
+}
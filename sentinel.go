@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// newSentinel returns a marker that nothing a command prints will contain by
+// chance, so that finding it means the command has finished.
+func newSentinel() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "__DONE_" + hex.EncodeToString(b) + "__"
+}
+
+// sentinelEcho is the command that prints sentinel with the exit status of
+// the command before it.
+func sentinelEcho(sentinel string) string {
+	return "echo " + sentinel + ":$?"
+}
+
+// sentinelLine matches what sentinelEcho prints. It isn't anchored to the
+// start of a line, since after a command that prints nothing it follows the
+// prompt. The terminal's echo of the command line has the sentinel in it too,
+// but not followed by a number.
+func sentinelLine(sentinel string) *regexp.Regexp {
+	return regexp.MustCompile(`(?m)` + regexp.QuoteMeta(sentinel) + `:(\d+)\r?$`)
+}
+
+// clearPromptCommand sets the shell's prompts to nothing.
+const clearPromptCommand = "PS1= PS2="
+
+// clearPrompt turns off the shell's prompt. runSentinelCommand has no use for
+// it, and since it is printed after a command's sentinel it would start the
+// output of the next command. What was printed before, such as the first
+// prompt, is thrown away.
+func (r *RemoteWorker) clearPrompt() {
+	r.runSentinelCommand(clearPromptCommand)
+}
+
+// runSentinelCommand is runCommand for RemoteWorker.sentinels. The output
+// includes everything printed since the previous command's sentinel, such as
+// the shell's echo of the command line, so the prompt must have been turned
+// off with clearPrompt.
+func (r *RemoteWorker) runSentinelCommand(command string) (string, int) {
+	sentinel := newSentinel()
+	line := sentinelLine(sentinel)
+	r.mu.Lock()
+	r.sentinel = sentinel
+	r.mu.Unlock()
+	r.remoteCommand(command + "; " + sentinelEcho(sentinel))
+	// Only the last line read can be the sentinel's, so that is all that is
+	// searched each time.
+	output := r.readUntil('\n', func(text string) (string, bool) {
+		last := strings.LastIndexByte(strings.TrimSuffix(text, "\n"), '\n') + 1
+		if loc := line.FindStringIndex(text[last:]); loc != nil {
+			return text[:last+loc[1]], true
+		}
+		return "", false
+	})
+	return parseSentinel(output, sentinel)
+}
+
+// parseSentinel splits the output of a command sent by runSentinelCommand
+// into the command's own output and its exit status, which is -1 if the
+// sentinel isn't there.
+func parseSentinel(output, sentinel string) (string, int) {
+	matched := sentinelLine(sentinel).FindStringSubmatchIndex(output)
+	if matched == nil {
+		return output, -1
+	}
+	status, _ := strconv.Atoi(output[matched[2]:matched[3]])
+	return output[:matched[0]], status
+}
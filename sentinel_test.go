@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseSentinel(t *testing.T) {
+	const sentinel = "__DONE_0123456789abcdef__"
+	tests := []struct {
+		name   string
+		output string
+		want   string
+		status int
+	}{
+		{"passes", "ok\n" + sentinel + ":0\n", "ok\n", 0},
+		{"fails", "FAIL\r\n" + sentinel + ":1\r\n", "FAIL\r\n", 1},
+		{"echoed command line", "go test; echo " + sentinel + ":$?\r\nok\r\n" + sentinel + ":0\r\n",
+			"go test; echo " + sentinel + ":$?\r\nok\r\n", 0},
+		{"after the prompt", "ci@homework1:~/juju$ " + sentinel + ":1\n", "ci@homework1:~/juju$ ", 1},
+		{"another sentinel", "ok\n__DONE_fedcba9876543210__:0\n", "ok\n__DONE_fedcba9876543210__:0\n", -1},
+		{"missing", "ok\n", "ok\n", -1},
+	}
+	for _, test := range tests {
+		output, status := parseSentinel(test.output, sentinel)
+		if output != test.want || status != test.status {
+			t.Errorf("%s: parseSentinel() = %q, %d, want %q, %d", test.name, output, status, test.want, test.status)
+		}
+	}
+}
+
+func TestNewSentinel(t *testing.T) {
+	a, b := newSentinel(), newSentinel()
+	if a == b || !sentinelLine(a).MatchString(a+":0") {
+		t.Errorf("newSentinel() = %q then %q", a, b)
+	}
+}
+
+func TestRunSentinelCommand(t *testing.T) {
+	var shell *fakeShell
+	shell = newFakeShell(func(command string) (string, int) {
+		switch command {
+		case "ls":
+			return "state_test.go\n", 0
+		case "false":
+			return "", 1
+		case "sleep 3600":
+			// Runs until it is interrupted.
+			<-shell.interrupts
+			return "^C\n", -1
+		}
+		return "", 127
+	})
+	r := newFakeWorker(t, shell)
+	r.sentinels = true
+	r.clearPrompt()
+
+	// Without a prompt, the output is only the command's own.
+	if output, status := r.runCommand("ls"); output != "state_test.go\n" || status != 0 {
+		t.Errorf("ls: %q, %d, want %q, 0", output, status, "state_test.go\n")
+	}
+	if output, status := r.runCommand("false"); output != "" || status != 1 {
+		t.Errorf("false: %q, %d, want \"\", 1", output, status)
+	}
+
+	// An interrupt abandons the echo of the sentinel, so it is sent again.
+	r.setCurrent("state")
+	done := make(chan string)
+	go func() {
+		output, status := r.runCommand("sleep 3600")
+		done <- fmt.Sprintf("%q, %d", output, status)
+	}()
+	for shell.Commands()[len(shell.Commands())-1] != "sleep 3600" {
+		time.Sleep(time.Millisecond)
+	}
+	r.cancel("state")
+	select {
+	case got := <-done:
+		if want := `"^C\n", 130`; got != want {
+			t.Errorf("interrupted command: %s, want %s", got, want)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the interrupted command didn't finish")
+	}
+}
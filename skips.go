@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+)
+
+// skippedTest matches the line go test -v prints for a skipped test or
+// subtest, capturing the test's name.
+var skippedTest = regexp.MustCompile(`(?m)^\s*--- SKIP: (\S+)`)
+
+// findSkips returns the names of the tests that output shows were skipped.
+func findSkips(output string) []string {
+	var skips []string
+	for _, match := range skippedTest.FindAllStringSubmatch(output, -1) {
+		skips = append(skips, match[1])
+	}
+	return skips
+}
+
+// unexpectedSkips returns the skipped tests in results, as package.Test,
+// that aren't in allowed. A test can be allowed by its name alone or
+// qualified by its package.
+func unexpectedSkips(results []Result, allowed map[string]bool) []string {
+	var skips []string
+	for _, result := range results {
+		for _, test := range result.Skipped {
+			qualified := result.Package + "." + test
+			if !allowed[test] && !allowed[qualified] {
+				skips = append(skips, qualified)
+			}
+		}
+	}
+	return skips
+}
+
+// writeSkipSummary writes the unexpected skipped tests to w if there are
+// more than limit of them, and reports whether there were.
+func writeSkipSummary(w io.Writer, skips []string, limit int) bool {
+	if len(skips) <= limit {
+		return false
+	}
+	fmt.Fprintf(w, "%d tests skipped, over -max-skips %d:\n", len(skips), limit)
+	for _, test := range skips {
+		fmt.Fprintf(w, "  %s\n", test)
+	}
+	return true
+}
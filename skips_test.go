@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestFindSkips(t *testing.T) {
+	output := `=== RUN   TestLogin
+--- SKIP: TestLogin (0.00s)
+    login_test.go:12: needs a controller
+=== RUN   TestWatch
+    --- SKIP: TestWatch/slow (0.00s)
+--- PASS: TestWatch (0.01s)
+--- FAIL: TestAddUnit (0.02s)
+PASS
+`
+	if got, want := findSkips(output), []string{"TestLogin", "TestWatch/slow"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("findSkips() = %q, want %q", got, want)
+	}
+	if got := findSkips("ok  \tgithub.com/juju/juju/state\t0.012s\n"); got != nil {
+		t.Errorf("findSkips() = %q for output without skips", got)
+	}
+}
+
+func TestUnexpectedSkips(t *testing.T) {
+	results := []Result{
+		{Package: "state", Skipped: []string{"TestLogin", "TestWatch/slow"}},
+		{Package: "api", Skipped: []string{"TestLogin", "TestMacaroons"}},
+		{Package: "cmd"},
+	}
+	tests := []struct {
+		name    string
+		allowed map[string]bool
+		want    []string
+	}{
+		{"none allowed", nil, []string{"state.TestLogin", "state.TestWatch/slow", "api.TestLogin", "api.TestMacaroons"}},
+		{"by name", map[string]bool{"TestLogin": true}, []string{"state.TestWatch/slow", "api.TestMacaroons"}},
+		{"by package", map[string]bool{"api.TestLogin": true, "state.TestWatch/slow": true},
+			[]string{"state.TestLogin", "api.TestMacaroons"}},
+	}
+	for _, test := range tests {
+		if got := unexpectedSkips(results, test.allowed); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: unexpectedSkips() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestWriteSkipSummary(t *testing.T) {
+	skips := []string{"state.TestLogin", "api.TestMacaroons"}
+	tests := []struct {
+		limit  int
+		excess bool
+		want   string
+	}{
+		{0, true, "2 tests skipped, over -max-skips 0:\n  state.TestLogin\n  api.TestMacaroons\n"},
+		{1, true, "2 tests skipped, over -max-skips 1:\n  state.TestLogin\n  api.TestMacaroons\n"},
+		{2, false, ""},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if excess := writeSkipSummary(&buf, skips, test.limit); excess != test.excess {
+			t.Errorf("writeSkipSummary(%d) = %t, want %t", test.limit, excess, test.excess)
+		}
+		if buf.String() != test.want {
+			t.Errorf("writeSkipSummary(%d) wrote %q, want %q", test.limit, buf.String(), test.want)
+		}
+	}
+}
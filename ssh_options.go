@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// stringList is a flag.Value that collects every use of a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// sshOptions are the subset of OpenSSH's -o options that we understand.
+type sshOptions struct {
+	User                  string
+	Port                  string
+	ConnectTimeout        time.Duration
+	StrictHostKeyChecking string
+	IdentityFiles         []string
+	// KnownHostsFiles are checked for host keys, in order, instead of
+	// ~/.ssh/known_hosts. They come from -known-hosts rather than -o.
+	KnownHostsFiles []string
+}
+
+// parseSSHOptions parses options given in the OpenSSH -o forms Option=Value
+// and "Option Value". As with OpenSSH, option names are case insensitive.
+func parseSSHOptions(options []string) (sshOptions, error) {
+	var opts sshOptions
+	for _, option := range options {
+		name, value, ok := strings.Cut(option, "=")
+		if !ok {
+			name, value, ok = strings.Cut(option, " ")
+		}
+		name = strings.TrimSpace(name)
+		value = strings.TrimSpace(value)
+		if !ok || value == "" {
+			return opts, fmt.Errorf("option %q has no value", option)
+		}
+
+		switch strings.ToLower(name) {
+		case "user":
+			opts.User = value
+		case "port":
+			if _, err := strconv.ParseUint(value, 10, 16); err != nil {
+				return opts, fmt.Errorf("bad Port %q", value)
+			}
+			opts.Port = value
+		case "connecttimeout":
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds < 0 {
+				return opts, fmt.Errorf("bad ConnectTimeout %q", value)
+			}
+			opts.ConnectTimeout = time.Duration(seconds) * time.Second
+		case "stricthostkeychecking":
+			switch strings.ToLower(value) {
+			case "yes", "no":
+				opts.StrictHostKeyChecking = strings.ToLower(value)
+			default:
+				return opts, fmt.Errorf("StrictHostKeyChecking must be yes or no, not %q", value)
+			}
+		case "identityfile":
+			opts.IdentityFiles = append(opts.IdentityFiles, value)
+		default:
+			return opts, fmt.Errorf("unsupported option %q", name)
+		}
+	}
+	return opts, nil
+}
+
+// port is the port to connect to, 22 unless Port was given.
+func (o sshOptions) port() string {
+	if o.Port == "" {
+		return "22"
+	}
+	return o.Port
+}
+
+// hostKeyCallback returns the host key check to use. Host keys are checked
+// against KnownHostsFiles if there are any, or otherwise against
+// ~/.ssh/known_hosts. StrictHostKeyChecking "no" accepts any key.
+func (o sshOptions) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	if o.StrictHostKeyChecking == "no" {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	files := o.KnownHostsFiles
+	if len(files) == 0 {
+		files = []string{"~/.ssh/known_hosts"}
+	}
+	var paths []string
+	for _, path := range files {
+		path, err := expandHome(path)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return knownhosts.New(paths...)
+}
+
+// expandHome replaces a leading ~/ in path with the user's home directory.
+func expandHome(path string) (string, error) {
+	if !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, path[2:]), nil
+}
+
+// identityAuth returns an auth method that uses the keys in IdentityFiles, or
+// nil if there are none.
+func (o sshOptions) identityAuth() (ssh.AuthMethod, error) {
+	if len(o.IdentityFiles) == 0 {
+		return nil, nil
+	}
+	var signers []ssh.Signer
+	for _, path := range o.IdentityFiles {
+		path, err := expandHome(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		signers = append(signers, signer)
+	}
+	return ssh.PublicKeys(signers...), nil
+}
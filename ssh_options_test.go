@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestParseSSHOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []string
+		want    sshOptions
+		err     string
+	}{{
+		name:    "equals",
+		options: []string{"User=ci", "Port=2222"},
+		want:    sshOptions{User: "ci", Port: "2222"},
+	}, {
+		name:    "space and case",
+		options: []string{"connecttimeout 10", "STRICTHOSTKEYCHECKING=No"},
+		want:    sshOptions{ConnectTimeout: 10 * time.Second, StrictHostKeyChecking: "no"},
+	}, {
+		name:    "identity files accumulate",
+		options: []string{"IdentityFile=~/.ssh/farm", "IdentityFile=/etc/farm/key"},
+		want:    sshOptions{IdentityFiles: []string{"~/.ssh/farm", "/etc/farm/key"}},
+	}, {
+		name:    "later wins",
+		options: []string{"User=ci", "User=juju"},
+		want:    sshOptions{User: "juju"},
+	}, {
+		name:    "no value",
+		options: []string{"User="},
+		err:     `option "User=" has no value`,
+	}, {
+		name:    "bad port",
+		options: []string{"Port=70000"},
+		err:     `bad Port "70000"`,
+	}, {
+		name:    "bad timeout",
+		options: []string{"ConnectTimeout=10s"},
+		err:     `bad ConnectTimeout "10s"`,
+	}, {
+		name:    "bad host key checking",
+		options: []string{"StrictHostKeyChecking=accept-new"},
+		err:     `StrictHostKeyChecking must be yes or no, not "accept-new"`,
+	}, {
+		name:    "unsupported",
+		options: []string{"ProxyJump=bastion"},
+		err:     `unsupported option "ProxyJump"`,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseSSHOptions(test.options)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("parseSSHOptions() error = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseSSHOptions() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSSHOptionsPort(t *testing.T) {
+	if got := (sshOptions{}).port(); got != "22" {
+		t.Errorf("default port = %q, want 22", got)
+	}
+	if got := (sshOptions{Port: "2222"}).port(); got != "2222" {
+		t.Errorf("port = %q, want 2222", got)
+	}
+}
+
+// newHostKey returns a new ed25519 host key.
+func newHostKey(t *testing.T) ssh.PublicKey {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	known := newHostKey(t)
+	other := newHostKey(t)
+	line := knownhosts.Line([]string{knownhosts.Normalize("homework1:22")}, known) + "\n"
+	if err := os.MkdirAll(filepath.Join(home, ".ssh"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{filepath.Join(home, ".ssh", "known_hosts"), filepath.Join(home, "farm_hosts")} {
+		if err := os.WriteFile(path, []byte(line), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addr := &net.TCPAddr{IP: net.IPv4(192, 168, 1, 10), Port: 22}
+
+	tests := []struct {
+		name      string
+		options   sshOptions
+		acceptAny bool
+	}{
+		{"default", sshOptions{}, false},
+		{"no", sshOptions{StrictHostKeyChecking: "no", KnownHostsFiles: []string{"~/farm_hosts"}}, true},
+		{"yes", sshOptions{StrictHostKeyChecking: "yes"}, false},
+		{"known hosts files", sshOptions{KnownHostsFiles: []string{"~/farm_hosts"}}, false},
+		{"yes and known hosts files", sshOptions{StrictHostKeyChecking: "yes", KnownHostsFiles: []string{filepath.Join(home, "farm_hosts")}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			callback, err := test.options.hostKeyCallback()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := callback("homework1:22", addr, known); err != nil {
+				t.Errorf("the known key was rejected: %s", err)
+			}
+			err = callback("homework1:22", addr, other)
+			if test.acceptAny != (err == nil) {
+				t.Errorf("another key for homework1: %v, want accepted %t", err, test.acceptAny)
+			}
+			err = callback("homework2:22", addr, known)
+			if test.acceptAny != (err == nil) {
+				t.Errorf("an unknown host: %v, want accepted %t", err, test.acceptAny)
+			}
+		})
+	}
+}
+
+func TestHostKeyCallbackMissingFile(t *testing.T) {
+	options := sshOptions{KnownHostsFiles: []string{filepath.Join(t.TempDir(), "missing")}}
+	if _, err := options.hostKeyCallback(); err == nil {
+		t.Errorf("hostKeyCallback() didn't fail for a missing known hosts file")
+	}
+}
+
+func TestSetupChecksKnownHostsByDefault(t *testing.T) {
+	tests := []struct {
+		name  string
+		known bool
+		err   string
+	}{
+		{"known", true, ""},
+		{"another key", false, "unable to connect: ssh: handshake failed: knownhosts: key mismatch"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			home := t.TempDir()
+			t.Setenv("HOME", home)
+			startFakeAgent(t)
+			server := startFakeServer(t, "127.0.0.2", newFakeShell(nil))
+			key := server.hostKey
+			if !test.known {
+				key = newHostKey(t)
+			}
+			addr := knownhosts.Normalize(net.JoinHostPort(server.host, server.port))
+			if err := os.MkdirAll(filepath.Join(home, ".ssh"), 0700); err != nil {
+				t.Fatal(err)
+			}
+			line := knownhosts.Line([]string{addr}, key) + "\n"
+			if err := os.WriteFile(filepath.Join(home, ".ssh", "known_hosts"), []byte(line), 0600); err != nil {
+				t.Fatal(err)
+			}
+			// No host key options at all.
+			w := server.worker()
+			w.options = sshOptions{User: "ci", Port: server.port}
+
+			err := w.Setup(server.host, &sync.WaitGroup{})
+			defer w.Close()
+			if test.err == "" && err != nil {
+				t.Fatalf("Setup() = %q", err)
+			}
+			if test.err != "" && (err == nil || err.Error() != test.err) {
+				t.Fatalf("Setup() = %v, want %q", err, test.err)
+			}
+		})
+	}
+}
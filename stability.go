@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// packageStability is how often a package passed across repeated runs.
+type packageStability struct {
+	Package string
+	Passes  int
+	Runs    int
+}
+
+// Ratio is the fraction of runs that passed.
+func (s packageStability) Ratio() float64 {
+	if s.Runs == 0 {
+		return 0
+	}
+	return float64(s.Passes) / float64(s.Runs)
+}
+
+// stability returns the pass ratio of each package in queue from the runs
+// recorded in outcomes, least stable first. Packages that are equally stable
+// stay in queue order.
+func stability(queue []string, outcomes map[string][]bool) []packageStability {
+	var stabilities []packageStability
+	seen := make(map[string]bool)
+	for _, pkg := range queue {
+		if seen[pkg] {
+			continue
+		}
+		seen[pkg] = true
+		stabilities = append(stabilities, packageStability{
+			Package: pkg,
+			Passes:  countPasses(outcomes[pkg]),
+			Runs:    len(outcomes[pkg]),
+		})
+	}
+	sort.SliceStable(stabilities, func(i, j int) bool {
+		return stabilities[i].Ratio() < stabilities[j].Ratio()
+	})
+	return stabilities
+}
+
+// writeStabilityReport writes the pass ratio of each package to w.
+func writeStabilityReport(w io.Writer, stabilities []packageStability) {
+	fmt.Fprintln(w, "stability:")
+	for _, s := range stabilities {
+		fmt.Fprintf(w, "  %s: passed %d of %d runs (%.0f%%)\n",
+			s.Package, s.Passes, s.Runs, 100*s.Ratio())
+	}
+}
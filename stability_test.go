@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestStability(t *testing.T) {
+	queue := []string{"state", "api", "cmd", "state", "lease", "rpc"}
+	outcomes := map[string][]bool{
+		"state": {true, false, true, true},
+		"api":   {true, true, true, true},
+		"cmd":   {false, false, true, false},
+		"lease": {true, true, false, true},
+	}
+	want := []packageStability{
+		{"rpc", 0, 0},
+		{"cmd", 1, 4},
+		{"state", 3, 4},
+		{"lease", 3, 4},
+		{"api", 4, 4},
+	}
+	if got := stability(queue, outcomes); !reflect.DeepEqual(got, want) {
+		t.Errorf("stability() = %+v, want %+v", got, want)
+	}
+}
+
+func TestWriteStabilityReport(t *testing.T) {
+	var buf bytes.Buffer
+	writeStabilityReport(&buf, []packageStability{{"cmd", 1, 3}, {"api", 4, 4}, {"rpc", 0, 0}})
+	want := "stability:\n" +
+		"  cmd: passed 1 of 3 runs (33%)\n" +
+		"  api: passed 4 of 4 runs (100%)\n" +
+		"  rpc: passed 0 of 0 runs (0%)\n"
+	if buf.String() != want {
+		t.Errorf("writeStabilityReport() wrote\n%s\nwant\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// slowResults returns the results that took longer than limit.
+func slowResults(results []Result, limit time.Duration) []Result {
+	var slow []Result
+	for _, result := range results {
+		if result.Duration > limit {
+			slow = append(slow, result)
+		}
+	}
+	return slow
+}
+
+// writeSlowSummary writes the packages that took longer than limit, and by
+// how much, to w. Being slow doesn't fail a package. It writes nothing if no
+// package was slow.
+func writeSlowSummary(w io.Writer, results []Result, limit time.Duration) {
+	slow := slowResults(results, limit)
+	if len(slow) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "slow packages (over %s):\n", limit)
+	for _, result := range slow {
+		fmt.Fprintf(w, "  %s on %s: %s (+%s)\n", result.Package, result.Worker,
+			result.Duration.Round(time.Second), (result.Duration - limit).Round(time.Second))
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestWriteSlowSummary(t *testing.T) {
+	results := []Result{
+		{Package: "state", Worker: "homework1", Passed: true, Duration: 95 * time.Second},
+		{Package: "api", Worker: "homework2", Passed: true, Duration: 30 * time.Second},
+		{Package: "cmd/juju", Worker: "homework2", Passed: false, Duration: 61400 * time.Millisecond},
+		{Package: "lease", Worker: "homework1", Passed: true, Duration: time.Minute},
+	}
+	tests := []struct {
+		name  string
+		limit time.Duration
+		want  string
+	}{{
+		name:  "some slow",
+		limit: time.Minute,
+		want: "slow packages (over 1m0s):\n" +
+			"  state on homework1: 1m35s (+35s)\n" +
+			"  cmd/juju on homework2: 1m1s (+1s)\n",
+	}, {
+		name:  "none slow",
+		limit: 2 * time.Minute,
+		want:  "",
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			writeSlowSummary(&buf, results, test.limit)
+			if buf.String() != test.want {
+				t.Errorf("writeSlowSummary() wrote\n%s\nwant\n%s", buf.String(), test.want)
+			}
+		})
+	}
+}
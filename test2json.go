@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// testEvent is an event in the format printed by go test -json, as
+// described by go doc test2json.
+type testEvent struct {
+	Time    *time.Time `json:",omitempty"`
+	Action  string
+	Package string   `json:",omitempty"`
+	Test    string   `json:",omitempty"`
+	Elapsed *float64 `json:",omitempty"`
+	Output  string   `json:",omitempty"`
+}
+
+// testResultLine matches go test's line for a test passing, failing or being
+// skipped.
+var testResultLine = regexp.MustCompile(`^\s*--- (PASS|FAIL|SKIP): (\S+) \((\d+(?:\.\d+)?)s\)`)
+
+// testEvents returns result as go test -json would have reported it: the
+// package starting, a line of output at a time, each test that reported a
+// result in that output and then the package as a whole, all at the end of
+// its last attempt. A cancelled package is reported as skipped, and one that
+// never started as starting now.
+func testEvents(result Result) []testEvent {
+	start := result.Started
+	if start.IsZero() {
+		start = time.Now()
+	}
+	end := start.Add(result.Duration)
+	if n := len(result.AttemptTimes); n > 0 {
+		last := result.AttemptTimes[n-1]
+		end = last.Start.Add(last.Duration)
+	}
+	events := []testEvent{{Time: &start, Action: "start", Package: result.Package}}
+
+	output := strings.ReplaceAll(result.Output, "\r\n", "\n")
+	if result.Cancelled {
+		output += "testfarm: cancelled\n"
+	}
+	for _, line := range strings.SplitAfter(output, "\n") {
+		if line == "" {
+			continue
+		}
+		if !strings.HasSuffix(line, "\n") {
+			line += "\n"
+		}
+		events = append(events, testEvent{Time: &end, Action: "output", Package: result.Package, Output: line})
+		if matched := testResultLine.FindStringSubmatch(line); matched != nil {
+			elapsed, _ := strconv.ParseFloat(matched[3], 64)
+			events = append(events, testEvent{
+				Time:    &end,
+				Action:  strings.ToLower(matched[1]),
+				Package: result.Package,
+				Test:    matched[2],
+				Elapsed: &elapsed,
+			})
+		}
+	}
+
+	action := "fail"
+	switch {
+	case result.Cancelled:
+		action = "skip"
+	case result.Passed:
+		action = "pass"
+	}
+	elapsed := result.Duration.Seconds()
+	return append(events, testEvent{Time: &end, Action: action, Package: result.Package, Elapsed: &elapsed})
+}
+
+// writeTestEvents writes result to w as go test -json events, one JSON
+// object per line.
+func writeTestEvents(w io.Writer, result Result) error {
+	encoder := json.NewEncoder(w)
+	for _, event := range testEvents(result) {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
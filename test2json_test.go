@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// eventSummary is the part of a testEvent that the tests check, with the
+// time dropped and the elapsed time as -1 if there isn't one.
+type eventSummary struct {
+	Action  string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+func summarizeEvents(t *testing.T, events []testEvent, pkg string, start, end time.Time) []eventSummary {
+	var summaries []eventSummary
+	for i, event := range events {
+		if event.Package != pkg {
+			t.Errorf("event %d is for %q, want %q", i, event.Package, pkg)
+		}
+		want := end
+		if i == 0 {
+			want = start
+		}
+		if event.Time == nil || !event.Time.Equal(want) {
+			t.Errorf("event %d is at %v, want %v", i, event.Time, want)
+		}
+		elapsed := -1.0
+		if event.Elapsed != nil {
+			elapsed = *event.Elapsed
+		}
+		summaries = append(summaries, eventSummary{event.Action, event.Test, elapsed, event.Output})
+	}
+	return summaries
+}
+
+func TestTestEvents(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		result Result
+		want   []eventSummary
+	}{{
+		name: "fail",
+		result: Result{
+			Package: "state", Started: start, Duration: 1500 * time.Millisecond,
+			Output: "=== RUN   TestWatch\r\n--- PASS: TestWatch (0.25s)\r\n" +
+				"    --- SKIP: TestWatch/slow (0.00s)\r\n--- FAIL: TestAddUnit (1.10s)\r\nFAIL",
+		},
+		want: []eventSummary{
+			{"start", "", -1, ""},
+			{"output", "", -1, "=== RUN   TestWatch\n"},
+			{"output", "", -1, "--- PASS: TestWatch (0.25s)\n"},
+			{"pass", "TestWatch", 0.25, ""},
+			{"output", "", -1, "    --- SKIP: TestWatch/slow (0.00s)\n"},
+			{"skip", "TestWatch/slow", 0, ""},
+			{"output", "", -1, "--- FAIL: TestAddUnit (1.10s)\n"},
+			{"fail", "TestAddUnit", 1.1, ""},
+			{"output", "", -1, "FAIL\n"},
+			{"fail", "", 1.5, ""},
+		},
+	}, {
+		name:   "pass",
+		result: Result{Package: "state", Started: start, Duration: 2 * time.Second, Passed: true, Output: "ok  \tgithub.com/juju/juju/state\t2.000s\n"},
+		want: []eventSummary{
+			{"start", "", -1, ""},
+			{"output", "", -1, "ok  \tgithub.com/juju/juju/state\t2.000s\n"},
+			{"pass", "", 2, ""},
+		},
+	}, {
+		name: "retried",
+		result: Result{
+			Package: "state", Started: start, Duration: 5 * time.Second, Passed: true, Attempts: 2,
+			AttemptTimes: []attemptTime{{start, time.Second, 2, 0}, {start.Add(3 * time.Second), 2 * time.Second, 0, 0}},
+			Output:       "ok  \tgithub.com/juju/juju/state\t2.000s\n",
+		},
+		want: []eventSummary{
+			{"start", "", -1, ""},
+			{"output", "", -1, "ok  \tgithub.com/juju/juju/state\t2.000s\n"},
+			{"pass", "", 5, ""},
+		},
+	}, {
+		name:   "cancelled",
+		result: Result{Package: "state", Started: start, Cancelled: true},
+		want: []eventSummary{
+			{"start", "", -1, ""},
+			{"output", "", -1, "testfarm: cancelled\n"},
+			{"skip", "", 0, ""},
+		},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			end := start.Add(test.result.Duration)
+			got := summarizeEvents(t, testEvents(test.result), "state", start, end)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("testEvents() =\n%+v\nwant\n%+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWriteTestEvents(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	result := Result{Package: "state", Started: start, Duration: time.Second, Passed: true, Output: "ok\n"}
+	if err := writeTestEvents(&buf, result); err != nil {
+		t.Fatal(err)
+	}
+	// Each line is an object with the fields go test -json uses.
+	var actions []string
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var event map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("bad line %q: %s", scanner.Text(), err)
+		}
+		if event["Time"] == nil || event["Package"] != "state" {
+			t.Errorf("line %q has no time or package", scanner.Text())
+		}
+		actions = append(actions, event["Action"].(string))
+	}
+	if want := []string{"start", "output", "pass"}; !reflect.DeepEqual(actions, want) {
+		t.Errorf("wrote actions %q, want %q", actions, want)
+	}
+}
@@ -2,25 +2,163 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"net/url"
 	"os"
+	"os/signal"
 	"os/user"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
 )
 
+var ordered = flag.Bool("ordered", false,
+	"print results in the order packages were queued rather than as they complete")
+var verifyRepro = flag.Int("verify-repro", 1,
+	"run each package N times and report packages whose pass/fail differs between runs")
+var timeoutsFile = flag.String("timeouts", "",
+	"file of per-package go test timeouts, one package and duration per line (default "+defaultTimeoutsFile+" if it exists)")
+var fullRepeat = flag.Int("full-repeat", 1,
+	"test the whole package set N times and report each package's pass ratio; the runs are queued one after another but may overlap")
+var onlyFailedFrom = flag.String("only-failed-from", "",
+	"only test the packages listed, one per line, in this file (- for stdin)")
+var transcriptDir = flag.String("transcript-dir", "",
+	"write a timestamped transcript of each worker's session to a file in this directory")
+var shell = flag.String("shell", "",
+	"shell to start on workers, e.g. /bin/bash (default is the user's login shell)")
+var integrationWorkers = flag.String("integration-workers", "",
+	"comma separated workers that may run integration packages (default all)")
+var integrationPackages = flag.String("integration-packages", "",
+	"comma separated packages or @aliases to treat as integration packages, as well as featuretests")
+var bench = flag.String("bench", "",
+	"run benchmarks matching this regexp instead of tests and report the results")
+var benchBaseline = flag.String("bench-baseline", "",
+	"go test -bench output to compare -bench results against")
+var readyCmd = flag.String("ready-cmd", "",
+	"command that must exit zero on a worker before it is used; workers that fail it are excluded")
+var readyRetries = flag.Int("ready-retries", 3, "number of times to try -ready-cmd")
+var readyTimeout = flag.Duration("ready-timeout", 30*time.Second, "time limit for each -ready-cmd attempt")
+var bootstrap = flag.String("bootstrap", "",
+	"shell script to upload to each worker and source before running anything")
+var tailLines = flag.Int("tail-lines", 0,
+	"only keep the last N lines of output from passing packages (default keep everything)")
+var maxOutputLines = flag.Int("max-output-lines", 10000,
+	"with -tail-lines, the most lines of output to keep from a failing package, from its first FAIL or panic line")
+var maxPromptBuffer = flag.Int("max-prompt-buffer", 0,
+	"fail a package whose command writes more than this many bytes without a prompt, and interrupt it (0 for no limit)")
+var recentCommands = flag.Int("recent-commands", 0,
+	"add the last N commands sent to a worker to the output of a package that fails on it")
+var controlSocket = flag.String("control", "",
+	"listen for control commands, such as adding a worker, on this Unix socket")
+var otlpEndpoint = flag.String("otlp-endpoint", "",
+	"post an OpenTelemetry trace of the run, with a span per package, to this OTLP/HTTP traces URL, e.g. http://localhost:4318/v1/traces; "+
+		"each package's tests are then built before they are run, so that building and testing get spans of their own")
+var resultWebhookURL = flag.String("result-webhook", "",
+	"post each package's result to this URL")
+var resultWebhookTemplate = flag.String("result-webhook-template", "",
+	"text/template for -result-webhook payloads, or @file to read it from a file")
+var htmlOut = flag.String("html-out", "", "write an HTML report of the results to this file")
+var jsonOut = flag.String("json-out", "",
+	"write the results to this file as go test -json events, as each package finishes")
+var inventoryFile = flag.String("inventory", "",
+	"JSON file describing the workers and packages to use")
+var markers = flag.Bool("markers", false,
+	"print >>> BEGIN and <<< END lines around each package's output")
+var smoke = flag.String("smoke", "",
+	"package to test on every worker before the run; workers it fails on are excluded")
+var serial = flag.Bool("serial", false,
+	"test every package on a single worker, one after another")
+var order = flag.String("order", "",
+	"comma separated packages to test, in this order, instead of the default order")
+var retryExitCodesFlag = flag.String("retry-exit-codes", "",
+	"comma separated go test exit statuses that mean a package should be retried")
+var maxRetries = flag.Int("max-retries", 1,
+	"how many times to retry a package that exits with one of -retry-exit-codes")
+var resultsFD = flag.Int("results-fd", -1,
+	"write a JSON line for each package's result to this open file descriptor")
+var warnClockSkew = flag.Duration("warn-clock-skew", 0,
+	"warn about workers whose clock differs from ours by more than this")
+var maxClockSkew = flag.Duration("max-clock-skew", 0,
+	"exclude workers whose clock differs from ours by more than this")
+var warnSlow = flag.Duration("warn-slow", 0,
+	"list packages that take longer than this in the summary, without failing them")
+var modDownload = flag.Bool("mod-download", false,
+	"run go mod download on each worker before testing, so packages don't race to download modules")
+var isolateGoCache = flag.Bool("isolate-go-cache", false,
+	"give each worker its own temporary module and build cache for the run, removed at the end")
+var modCheck = flag.Bool("mod-check", false,
+	"run go mod verify and go mod tidy -diff on each worker before testing, and don't use it if they fail")
+var captureEnv = flag.Bool("capture-env", false,
+	"report each worker's go env, OS release and tool versions")
+var drainTimeout = flag.Duration("drain-timeout", time.Minute,
+	"on interrupt, how long to wait for packages in progress before killing them")
+var retryRunOnMassFailure = flag.Float64("retry-run-on-mass-failure", 0,
+	"retry the whole run once if more than this fraction of workers aren't ready or are lost, e.g. 0.5 (0 never retries); "+
+		"results then go to -result-webhook, -results-fd and -json-out only once the run has ended without a retry")
+var massFailureBackoff = flag.Duration("mass-failure-backoff", time.Minute,
+	"how long to wait before retrying a run after a mass failure")
+var sentinelSync = flag.Bool("sentinel-sync", false,
+	"wait for a random sentinel echoed after each command, instead of the shell prompt, to know it has finished")
+var bisectFlaky = flag.Int("bisect-flaky", 0,
+	"when a package fails, rerun its tests N times at a go with a narrowing -run to find the failing test (0 doesn't)")
+var maxLoad = flag.Float64("max-load", 0,
+	"don't give a worker another package while its 1 minute load average per CPU is above this (0 doesn't check)")
+var loadBackoff = flag.Duration("load-backoff", 30*time.Second,
+	"how often to check the load of a worker held back by -max-load")
+var listWorkers = flag.Bool("list-workers", false,
+	"print the workers that would be used, with their settings, and exit")
+var dumpConfig = flag.Bool("dump-config", false,
+	"print the effective configuration as JSON and exit")
+var maxSkips = flag.Int("max-skips", -1,
+	"fail the run if more tests than this are skipped, not counting -allow-skip tests (-1 for no limit)")
+var sshOptionFlags stringList
+var aliasFlags stringList
+var pinFlags stringList
+var knownHostsFlags stringList
+var allowSkipFlags stringList
+var redactFlags stringList
+var dialerFlags stringList
+
+func init() {
+	flag.Var(&sshOptionFlags, "o",
+		"SSH option in OpenSSH's Option=Value form; one of ConnectTimeout, "+
+			"StrictHostKeyChecking, User, Port or IdentityFile (repeatable)")
+	flag.Var(&aliasFlags, "alias",
+		"define a package alias, name=package[,package...], used as @name (repeatable)")
+	flag.Var(&knownHostsFlags, "known-hosts",
+		"known_hosts file to check worker host keys against, instead of ~/.ssh/known_hosts (repeatable)")
+	flag.Var(&pinFlags, "pin",
+		"only test packages on one worker, worker=package[,package...], where a package may be an @alias (repeatable)")
+	flag.Var(&allowSkipFlags, "allow-skip",
+		"test that may be skipped without counting towards -max-skips, as Test or package.Test (repeatable)")
+	flag.Var(&redactFlags, "redact",
+		"regular expression for secrets to replace with "+redacted+" in output, reports and transcripts (repeatable)")
+	flag.Var(&dialerFlags, "dialer",
+		"dialer for inventory workers to use, name=command; the command is run like ProxyCommand, "+
+			"with %h and %p replaced by the host and port, and its stdin and stdout are the connection (repeatable)")
+}
+
 // RemoteWorker is all the information we need to maintain a connection to a
 // remote machine over SSH.
 type RemoteWorker struct {
 	reader         *bufio.Reader
 	stdin          io.WriteCloser
-	promptMatch    *regexp.Regexp
+	promptMatches  []*regexp.Regexp
+	promptPattern  string
 	ssh_agent_conn net.Conn
 	conn           *ssh.Client
 	ag             agent.Agent
@@ -28,61 +166,387 @@ type RemoteWorker struct {
 	session        *ssh.Session
 	stdout         io.Reader
 	wg             *sync.WaitGroup
+	shell          string
+	host           string
+	sent           *countingWriter
+	received       *countingReader
+	transcriptDir  string
+	transcript     *transcript
+	options        sshOptions
+	bench          string
+	bootstrap      string
+	tailLines      int
+	maxOutputLines int
+	// retained, while set, is given the output of the command being run
+	// as it is read.
+	retained       *retainedOutput
+	retryExitCodes map[int]bool
+	maxRetries     int
+	verbose        bool
+	timeouts       map[string]time.Duration
+	repoPath       string
+	redact         redactor
+	dialer         string
+	// goVersion, if set, is the go version the worker must have.
+	goVersion string
+	// With timeBuild set, a package's tests are built before go test
+	// runs them, to time the two apart.
+	timeBuild   bool
+	warmupTimes []warmupTime
+	env         *remoteEnv
+	stop        <-chan struct{}
+	dispatch    *dispatch
+	killed      atomic.Bool
+	// lost is set if the session ended without the worker being killed.
+	lost atomic.Bool
+	// mu guards current, the package being tested or "" between packages,
+	// and cancelling, which is set when it is cancelled.
+	mu         sync.Mutex
+	current    string
+	cancelling bool
+	// With sentinels set, runCommand waits for a sentinel echoed after
+	// each command instead of the prompt. sentinel, guarded by mu, is the
+	// one being waited for.
+	sentinels bool
+	sentinel  string
+	// promptLimit is the most output waitForPrompt reads without a prompt
+	// before treating the command as a runaway, or 0 for no limit.
+	promptLimit int
+	runaway     bool
+	// history is the last few commands sent, for failure reports.
+	history commandHistory
+	// goCacheDir holds the temporary go caches made by isolateGoCache.
+	goCacheDir string
+	// bisectCount, if set, is the -count used to find the failing test in
+	// a package that fails.
+	bisectCount int
+	// With maxLoad set, the worker waits before each package until its
+	// load average per CPU is no more than that, checking every
+	// loadBackoff.
+	maxLoad     float64
+	loadBackoff time.Duration
+}
+
+// transcript records everything sent to and received from a worker, one
+// timestamped line per write or read, in the order it happened. Each write or
+// read is redacted on its own, so a secret split across two isn't.
+type transcript struct {
+	mu     sync.Mutex
+	f      *os.File
+	redact redactor
+}
+
+// record writes data to the transcript. direction is ">" for data sent to
+// the worker and "<" for data received from it.
+func (t *transcript) record(direction string, data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.f, "%s %s %q\n", time.Now().Format(time.RFC3339Nano), direction, t.redact.Redact(string(data)))
+}
+
+// transcriptWriter is an io.Writer that records what is written to it in a
+// transcript.
+type transcriptWriter struct {
+	t         *transcript
+	direction string
+}
+
+func (w transcriptWriter) Write(p []byte) (int, error) {
+	w.t.record(w.direction, p)
+	return len(p), nil
+}
+
+// countingWriter counts the bytes written through it. It is safe to write to
+// from several goroutines.
+type countingWriter struct {
+	mu sync.Mutex
+	w  io.WriteCloser
+	n  int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// add counts n bytes sent some other way.
+func (c *countingWriter) add(n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.n += n
+}
+
+func (c *countingWriter) Close() error {
+	return c.w.Close()
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// promptPatterns are the candidate prompt regular expressions, tried in order
+// until one matches. Each is a format string that is passed the username and
+// host name. Every pattern must end in a $ because waitForPrompt reads up to
+// the next $ before trying them.
+var promptPatterns = []string{
+	"(?s)(^.*)%[1]s@%[2]s:.*\\$",       // Debian/Ubuntu: user@host:~/dir$
+	"(?s)(^.*)\\[%[1]s@%[2]s .*\\]\\$", // Red Hat/Fedora: [user@host dir]$
+	"(?s)(^.*)%[2]s:.* %[1]s\\$",       // macOS: host:dir user$
+}
+
+// compilePromptPatterns turns promptPatterns into regular expressions for the
+// given user and host.
+func compilePromptPatterns(username, host string) ([]*regexp.Regexp, error) {
+	var matches []*regexp.Regexp
+	for _, pattern := range promptPatterns {
+		re := fmt.Sprintf(pattern, regexp.QuoteMeta(username), regexp.QuoteMeta(host))
+		match, err := regexp.Compile(re)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, match)
+	}
+	return matches, nil
 }
 
 // waitForPrompt grabs text up to a $ then checks to see if that matched a
-// prompt using the regexp stored in RemoteWorker.promptMatch. If it doesn't
-// match the prompt it saves the text that it has so far and gets text up to
-// the next $
+// prompt using the regexps stored in RemoteWorker.promptMatches. If it doesn't
+// match a prompt it saves the text that it has so far and gets text up to
+// the next $. The first pattern to match is recorded in
+// RemoteWorker.promptPattern.
+//
+// With RemoteWorker.promptLimit set, a command that writes more than that
+// without a prompt is a runaway. It is interrupted, RemoteWorker.runaway is
+// set and only the start of its output is returned.
 func (r *RemoteWorker) waitForPrompt() string {
-	var line string
-	for {
-		chunk, _ := r.reader.ReadString('$')
-		//fmt.Printf(chunk)
-		line += chunk
-		if matched := r.promptMatch.FindStringSubmatch(line); matched != nil {
+	return r.readUntil('$', r.matchPrompt)
+}
+
+// matchPrompt returns the text before the prompt if line ends in one.
+func (r *RemoteWorker) matchPrompt(line string) (string, bool) {
+	for _, match := range r.promptMatches {
+		if matched := match.FindStringSubmatch(line); matched != nil {
 			//fmt.Printf("\n")
-			return matched[1]
+			if r.promptPattern != match.String() {
+				r.promptPattern = match.String()
+				log.Printf("prompt matched %q", r.promptPattern)
+			}
+			return matched[1], true
+		}
+	}
+	return "", false
+}
+
+// readUntil reads text up to delim until match finds what it is waiting for
+// in everything read so far, and returns what match does. match is only tried
+// once a read reaches delim, since a chunk that filled the reader's buffer
+// can't end in what it is waiting for. Runaway commands and lost sessions are
+// handled as described for waitForPrompt.
+//
+// With RemoteWorker.retained set, complete lines are passed to it as they are
+// read instead of being returned, all but the last one, which may be the
+// status or sentinel line that match is looking for.
+func (r *RemoteWorker) readUntil(delim byte, match func(string) (string, bool)) string {
+	var text strings.Builder
+	var kept string
+	// read counts everything read, including the lines passed on to
+	// RemoteWorker.retained.
+	read := 0
+	r.runaway = false
+	for {
+		chunk, err := r.reader.ReadSlice(delim)
+		text.Write(chunk)
+		read += len(chunk)
+		more := err == bufio.ErrBufferFull
+		if more {
+			// There is more to come before the next delimiter.
+			err = nil
+		}
+		if r.promptLimit > 0 && !r.runaway && read > r.promptLimit {
+			r.runaway = true
+			kept = text.String()[:r.promptLimit-(read-text.Len())] + fmt.Sprintf(runawayMessage, r.promptLimit)
+			log.Printf("%s: no prompt after %d bytes of output, interrupting the command", r.host, r.promptLimit)
+			r.mu.Lock()
+			r.interrupt()
+			r.mu.Unlock()
+		}
+		if r.runaway && text.Len() > runawayWindow {
+			window := text.String()[text.Len()-runawayWindow:]
+			text.Reset()
+			text.WriteString(window)
 		}
+		if r.retained != nil && !r.runaway {
+			r.retainLines(&text)
+		}
+		if !more {
+			if matched, ok := match(text.String()); ok {
+				if r.runaway {
+					return kept
+				}
+				return matched
+			}
+		}
+		// The session has gone, so no prompt is coming.
+		if err != nil {
+			if !r.killed.Load() {
+				r.lost.Store(true)
+			}
+			if r.runaway {
+				return kept
+			}
+			return text.String()
+		}
+	}
+}
+
+// retainLines passes the complete lines in text, but for the last one, to
+// RemoteWorker.retained and leaves only the rest in text.
+func (r *RemoteWorker) retainLines(text *strings.Builder) {
+	s := text.String()
+	end := strings.LastIndexByte(s, '\n')
+	if end < 0 {
+		return
+	}
+	cut := strings.LastIndexByte(s[:end], '\n') + 1
+	if cut == 0 {
+		return
+	}
+	for _, line := range strings.SplitAfter(s[:cut], "\n") {
+		if line != "" {
+			r.retained.addLine(r.redact.Redact(line))
+		}
+	}
+	text.Reset()
+	text.WriteString(s[cut:])
+}
+
+// interrupt sends Ctrl-C to whatever is running in the foreground of the
+// worker's terminal. The shell abandons the rest of the command line, so with
+// RemoteWorker.sentinels set the sentinel being waited for is sent again.
+// Both go in the history and the transcript like any other command. r.mu
+// must be held.
+func (r *RemoteWorker) interrupt() {
+	r.history.add("^C")
+	r.send([]byte{'\x03'})
+	if r.sentinel != "" {
+		echo := sentinelEcho(r.sentinel)
+		r.history.add(echo)
+		r.send([]byte(echo + "\n"))
 	}
 }
 
+// runawayMessage is added to the output kept from a runaway command.
+const runawayMessage = "\ntestfarm: no prompt after %d bytes of output (-max-prompt-buffer); the command was interrupted as a runaway\n"
+
+// runawayWindow is how much of a runaway command's output is searched for
+// the prompt once it has been interrupted. It only needs to hold the prompt.
+const runawayWindow = 4096
+
 // Send a command string, append a newline so it is executed
 func (r *RemoteWorker) remoteCommand(command string) {
-	fmt.Println(command)
-	r.stdin.Write([]byte(command + "\n"))
+	fmt.Println(r.redact.Redact(command))
+	r.history.add(r.redact.Redact(command))
+	r.send([]byte(command + "\n"))
+}
+
+// send writes data to the worker's terminal, recording it in the transcript.
+func (r *RemoteWorker) send(data []byte) {
+	if r.transcript != nil {
+		r.transcript.record(">", data)
+	}
+	r.stdin.Write(data)
+}
+
+// statusMarker is echoed along with a command's exit status by runCommand.
+const statusMarker = "testfarm-status:"
+
+var statusLine = regexp.MustCompile(statusMarker + `(\d+)`)
+
+// runCommand sends a command and waits for it to finish. It returns the
+// output of the command, with -redact applied, and its exit status, which is
+// -1 if it couldn't be found.
+func (r *RemoteWorker) runCommand(command string) (string, int) {
+	var output string
+	var status int
+	if r.sentinels {
+		output, status = r.runSentinelCommand(command)
+	} else {
+		r.remoteCommand(command + "; echo " + statusMarker + "$?")
+		output, status = parseStatus(r.waitForPrompt())
+	}
+	return r.redact.Redact(output), status
+}
+
+// parseStatus splits the output of a command sent by runCommand into the
+// command's own output and its exit status.
+func parseStatus(output string) (string, int) {
+	matches := statusLine.FindAllStringSubmatchIndex(output, -1)
+	if matches == nil {
+		return output, -1
+	}
+	last := matches[len(matches)-1]
+	status, _ := strconv.Atoi(output[last[2]:last[3]])
+	return output[:last[0]], status
 }
 
 // Setup initiates the SSH connection to a host and sets up the regular
-// expression to match the prompt.
-func (r *RemoteWorker) Setup(host string, wg *sync.WaitGroup) {
-	current_user, _ := user.Current()
-	username := current_user.Username
+// expression to match the prompt. Problems with the local configuration are
+// fatal, but an error connecting to the host or starting a shell on it is
+// returned so that the run can carry on without this worker.
+func (r *RemoteWorker) Setup(host string, wg *sync.WaitGroup) error {
+	username, err := loginName(r.options.User)
+	if err != nil {
+		log.Fatal(err)
+	}
 	r.wg = wg
+	r.host = host
 
-	var err error
 	r.ssh_agent_conn, err = net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
 	if err != nil {
 		log.Fatal(err)
 	}
 	r.ag = agent.NewClient(r.ssh_agent_conn)
 	auths := []ssh.AuthMethod{ssh.PublicKeysCallback(r.ag.Signers)}
+	identity, err := r.options.identityAuth()
+	if err != nil {
+		log.Fatalf("unable to load identity: %s", err)
+	}
+	if identity != nil {
+		auths = append([]ssh.AuthMethod{identity}, auths...)
+	}
+	hostKeyCallback, err := r.options.hostKeyCallback()
+	if err != nil {
+		log.Fatalf("unable to load known hosts: %s", err)
+	}
 
 	// Define the Client Config as :
 	r.config = &ssh.ClientConfig{
-		User: username,
-		Auth: auths,
+		User:            username,
+		Auth:            auths,
+		Timeout:         r.options.ConnectTimeout,
+		HostKeyCallback: hostKeyCallback,
 	}
 
 	// Connect to ssh server
-	r.conn, err = ssh.Dial("tcp", host+":22", r.config)
+	r.conn, err = dialSSH(r.dialer, net.JoinHostPort(host, r.options.port()), r.config)
 	if err != nil {
-		log.Fatalf("unable to connect: %s", err)
+		return fmt.Errorf("unable to connect: %s", err)
 	}
 	// Create a session
 	r.session, err = r.conn.NewSession()
 	if err != nil {
-		log.Fatalf("unable to create session: %s", err)
+		return fmt.Errorf("unable to create session: %s", err)
 	}
 	// Set up terminal modes
 	modes := ssh.TerminalModes{
@@ -91,65 +555,872 @@ func (r *RemoteWorker) Setup(host string, wg *sync.WaitGroup) {
 		ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
 	}
 
-	r.stdout, err = r.session.StdoutPipe()
+	stdout, err := r.session.StdoutPipe()
 	if err != nil {
-		log.Fatalf("unable to acquire stdout pipe: %s", err)
+		return fmt.Errorf("unable to acquire stdout pipe: %s", err)
+	}
+	r.received = &countingReader{r: stdout}
+	r.stdout = r.received
+	if r.transcriptDir != "" {
+		if err := os.MkdirAll(r.transcriptDir, 0755); err != nil {
+			log.Fatalf("unable to create transcript directory: %s", err)
+		}
+		f, err := os.Create(filepath.Join(r.transcriptDir, host+".transcript"))
+		if err != nil {
+			log.Fatalf("unable to create transcript: %s", err)
+		}
+		r.transcript = &transcript{f: f, redact: r.redact}
+		r.stdout = io.TeeReader(r.received, transcriptWriter{r.transcript, "<"})
 	}
 
-	r.stdin, err = r.session.StdinPipe()
+	stdin, err := r.session.StdinPipe()
 	if err != nil {
-		log.Fatalf("unable to acquire stdin pipe: %s", err)
+		return fmt.Errorf("unable to acquire stdin pipe: %s", err)
 	}
+	r.sent = &countingWriter{w: stdin}
+	r.stdin = r.sent
 
 	// Request pseudo terminal
 	if err := r.session.RequestPty("xterm", 80, 40, modes); err != nil {
-		log.Fatalf("request for pseudo terminal failed: %s", err)
+		return fmt.Errorf("request for pseudo terminal failed: %s", err)
 	}
 	// Start remote shell
-	if err := r.session.Shell(); err != nil {
-		log.Fatalf("failed to start shell: %s", err)
+	if r.shell == "" {
+		err = r.session.Shell()
+	} else {
+		err = r.session.Start(shellCommand(r.shell))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to start shell: %s", err)
 	}
 
 	r.reader = bufio.NewReader(r.stdout)
 
-	re := fmt.Sprintf("(?s)(^.*)%s@%s:.*\\$", username, host)
-	r.promptMatch, _ = regexp.Compile(re)
-	r.waitForPrompt()
+	r.promptMatches, err = compilePromptPatterns(username, host)
+	if err != nil {
+		log.Fatalf("bad prompt pattern: %s", err)
+	}
+	if r.sentinels {
+		r.clearPrompt()
+	} else {
+		r.waitForPrompt()
+	}
+
+	if r.bootstrap != "" {
+		if err := r.uploadFile(r.bootstrap, bootstrapPath); err != nil {
+			return fmt.Errorf("unable to upload bootstrap script: %s", err)
+		}
+		if output, status := r.runCommand(sourceCommand(bootstrapPath)); status != 0 {
+			return fmt.Errorf("bootstrap script failed with status %d: %s", status, strings.TrimSpace(output))
+		}
+	}
+	return nil
+}
+
+// currentUser returns the user running test_farm.
+var currentUser = user.Current
+
+// loginName returns the user to log in to workers as: configured if it is
+// set, otherwise the current user. In minimal containers the current user may
+// have no passwd entry, so after that it tries $USER and then $LOGNAME.
+func loginName(configured string) (string, error) {
+	if configured != "" {
+		return configured, nil
+	}
+	if current_user, err := currentUser(); err == nil && current_user.Username != "" {
+		return current_user.Username, nil
+	}
+	for _, name := range []string{"USER", "LOGNAME"} {
+		if username := os.Getenv(name); username != "" {
+			return username, nil
+		}
+	}
+	return "", fmt.Errorf("unable to find the current user; set one with -o User=name")
+}
+
+// shellCommand is the command used to start shell on a worker instead of the
+// user's default shell. It is started as a login shell so that it sets up the
+// same environment, and so the same prompt, as the default would.
+func shellCommand(shell string) string {
+	return shell + " -l"
 }
 
 // Close gracefully terminates the SSH connection and connection to the local
-// SSH agent.
+// SSH agent. Temporary go caches are removed first, if the shell is still
+// there to do it.
 func (r *RemoteWorker) Close() {
+	if r.goCacheDir != "" && !r.killed.Load() && !r.lost.Load() {
+		if _, status := r.runCommand(goCacheCleanupCommand(r.goCacheDir)); status != 0 {
+			log.Printf("unable to remove temporary go caches %s on %s", r.goCacheDir, r.host)
+		}
+		r.goCacheDir = ""
+	}
 	r.ssh_agent_conn.Close()
-	r.conn.Close()
+	if r.session != nil {
+		r.session.Close()
+	}
+	if r.conn != nil {
+		r.conn.Close()
+	}
+	if r.transcript != nil {
+		r.transcript.f.Close()
+	}
+}
+
+// defaultRepoPath is where the juju source is on workers that the inventory
+// doesn't give a repo_path for.
+const defaultRepoPath = "~/dev/go/src/github.com/juju/juju/"
+
+// Test a single juju package, returning the output of go test and its exit
+// status, or -1 if that couldn't be found.
+func (r *RemoteWorker) TestPackage(pkg string) (string, int) {
+	output, status, _ := r.testPackage(pkg, nil)
+	return output, status
+}
+
+// testPackage is TestPackage, giving the output of go test to retained as it
+// is read if that isn't nil. All of it is given to retained, and the output
+// returned is what retained keeps. With RemoteWorker.timeBuild set the tests
+// are built first, and how long that took is returned too.
+func (r *RemoteWorker) testPackage(pkg string, retained *retainedOutput) (string, int, time.Duration) {
+	r.runCommand("cd " + r.repoPath)
+	r.runCommand("cd " + pkg)
+	var build time.Duration
+	if r.timeBuild {
+		start := time.Now()
+		r.runCommand(buildTestsCommand)
+		build = time.Since(start)
+	}
+	if retained == nil {
+		output, status := r.runCommand(r.goTestCommand(pkg))
+		return output, status, build
+	}
+	r.retained = retained
+	output, status := r.runCommand(r.goTestCommand(pkg))
+	r.retained = nil
+	retained.addOutput(output)
+	return retained.String(), status, build
+}
+
+// buildTestsCommand builds the tests of a package and runs none of them.
+// Whether it works doesn't matter, since the go test after it fails in the
+// same way.
+const buildTestsCommand = "go test -run='^$' ./..."
+
+// goTestCommand is the go test command run in pkg. It runs the tests, or with
+// RemoteWorker.bench set, only the benchmarks that match it. With
+// RemoteWorker.verbose set the tests are run with -v, so that skips show up.
+// The timeout is the package's own from RemoteWorker.timeouts, if it has one.
+func (r *RemoteWorker) goTestCommand(pkg string) string {
+	timeout, ok := r.timeouts[pkg]
+	if !ok {
+		timeout = defaultTestTimeout
+	}
+	if r.bench != "" {
+		return fmt.Sprintf("go test -test.timeout=%s -run='^$' -bench=%s -benchmem ./...",
+			timeout, shellQuote(r.bench))
+	}
+	if r.verbose {
+		return fmt.Sprintf("go test -v -test.timeout=%s ./...", timeout)
+	}
+	return fmt.Sprintf("go test -test.timeout=%s ./...", timeout)
+}
+
+// shellQuote quotes s for use as a single word in a POSIX shell command.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// TestPackages receives names of packages to test on each of package_chans in
+// turn and returns their output on results_chan. Once there are no more
+// packages to test it closes the SSH connection and signals that it is done on
+// the wait group RemoteWorker.wg
+func (r *RemoteWorker) TestPackages(package_chans []chan string, results_chan chan Result) {
+	defer r.wg.Done()
+	defer r.Close()
+	for _, package_chan := range package_chans {
+		for {
+			r.dispatch.wait(r.stop)
+			r.waitForLoad(package_chan)
+			if r.stopped() {
+				return
+			}
+			pkg, ok := <-package_chan
+			if !ok {
+				break
+			}
+			if r.dispatch.isCancelled(pkg) {
+				results_chan <- Result{Package: pkg, Worker: r.host, Cancelled: true}
+				continue
+			}
+			r.setCurrent(pkg)
+			result := r.runPackage(pkg)
+			r.setCurrent("")
+			// A package that was killed part way through has no result,
+			// and nor does one that was running when the worker was lost.
+			if r.killed.Load() {
+				return
+			}
+			if r.lost.Load() {
+				log.Printf("lost connection to %s while testing %s", r.host, pkg)
+				if history := r.history.report(r.host); history != "" {
+					log.Print(history)
+				}
+				return
+			}
+			results_chan <- result
+		}
+	}
+}
+
+// Current returns the package the worker is testing, or "" if it is between
+// packages.
+func (r *RemoteWorker) Current() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+func (r *RemoteWorker) setCurrent(pkg string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current = pkg
+	r.cancelling = false
+}
+
+// cancel interrupts pkg if the worker is testing it, and reports whether it
+// was. The interrupt goes to whatever is running in the foreground of the
+// worker's terminal, as if Ctrl-C had been pressed there.
+func (r *RemoteWorker) cancel(pkg string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current != pkg {
+		return false
+	}
+	r.cancelling = true
+	r.interrupt()
+	return true
+}
+
+// cancelled reports whether the package being tested has been cancelled.
+func (r *RemoteWorker) cancelled() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cancelling
+}
+
+// stopped reports whether the worker has been told to stop taking packages.
+func (r *RemoteWorker) stopped() bool {
+	select {
+	case <-r.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// kill closes the worker's session, ending whatever it is running.
+func (r *RemoteWorker) kill() {
+	r.killed.Store(true)
 	r.session.Close()
+	r.conn.Close()
+}
+
+// runPackage tests pkg and returns its result. A failure with an exit status
+// in RemoteWorker.retryExitCodes is retried, up to RemoteWorker.maxRetries
+// times.
+func (r *RemoteWorker) runPackage(pkg string) Result {
+	var result Result
+	var attempts []attemptTime
+	var killed bool
+	for attempt := 1; ; attempt++ {
+		// Benchmark results are parsed from the output, so keep all of
+		// it.
+		retained := newRetainedOutput(0, 0)
+		if r.tailLines > 0 && r.bench == "" {
+			retained = newRetainedOutput(r.tailLines, r.maxOutputLines)
+		}
+		start := time.Now()
+		output, status, build := r.testPackage(pkg, retained)
+		attempts = append(attempts, attemptTime{start, time.Since(start), status, build})
+		killed = retained.killed
+		last := attempts[len(attempts)-1]
+		result = Result{
+			Package:      pkg,
+			Worker:       r.host,
+			Output:       output,
+			Passed:       !retained.failed && status <= 0,
+			ExitCode:     status,
+			Attempts:     attempt,
+			Duration:     last.Start.Add(last.Duration).Sub(attempts[0].Start),
+			LeakWarnings: retained.leaks,
+			Skipped:      retained.skips,
+			Started:      attempts[0].Start,
+			AttemptTimes: attempts,
+		}
+		if r.cancelled() {
+			result.Passed = false
+			result.Cancelled = true
+			return result
+		}
+		if r.runaway {
+			result.Passed = false
+			break
+		}
+		if result.Passed || attempt > r.maxRetries || !r.retryExitCodes[status] {
+			break
+		}
+		log.Printf("retrying %s on %s after exit status %d", pkg, r.host, status)
+	}
+	// The history is taken now, so that it ends with the go test that
+	// failed rather than what is run below to look into it.
+	var history string
+	if !result.Passed {
+		history = r.history.report(r.host)
+	}
+
+	if !result.Passed && killed {
+		output, _ := r.runCommand(kernelLogCommand(time.Since(result.Started)))
+		result.OOMKilled = oomKilled(output)
+	}
+	if !result.Passed && !r.runaway && r.bisectCount > 0 {
+		test, err := r.bisectFlaky(pkg)
+		if err != nil {
+			log.Printf("unable to find the failing test in %s on %s: %s", pkg, r.host, err)
+		} else {
+			result.FlakyTest = test
+		}
+	}
+	if history != "" {
+		if !strings.HasSuffix(result.Output, "\n") {
+			result.Output += "\n"
+		}
+		result.Output += history
+	}
+	return result
+}
+
+// Result is the output of testing a single package.
+type Result struct {
+	Package   string
+	Worker    string
+	Output    string
+	Passed    bool
+	ExitCode  int
+	Attempts  int
+	OOMKilled bool
+	// Duration is from the start of the first attempt to the end of the
+	// last.
+	Duration time.Duration
+	// LeakWarnings are lines from goroutine leak checkers in the output.
+	LeakWarnings []string
+	// Skipped are the names of the tests that were skipped.
+	Skipped []string
+	// Cancelled is set if the package was cancelled before or while it was
+	// tested.
+	Cancelled bool
+	// Started is when the first attempt started, and AttemptTimes how long
+	// each attempt took.
+	Started      time.Time
+	AttemptTimes []attemptTime
+	// FlakyTest is the failing test found by -bisect-flaky.
+	FlakyTest string
+}
+
+// attemptTime is when one attempt at testing a package started, how long it
+// took and the exit status it finished with. Build is how long building the
+// tests took, as part of Duration, if that was timed apart from running them.
+type attemptTime struct {
+	Start    time.Time
+	Duration time.Duration
+	ExitCode int
+	Build    time.Duration
+}
+
+// Status is "pass", "fail" or "cancelled".
+func (r Result) Status() string {
+	if r.Cancelled {
+		return "cancelled"
+	}
+	if r.Passed {
+		return "pass"
+	}
+	return "fail"
+}
+
+var killedBySignal = regexp.MustCompile(`signal: killed`)
+var oomEvidence = regexp.MustCompile(`(?i)out of memory|oom-kill|oom_reaper|killed process \d+`)
+
+// kernelLogCommand prints the kernel log for the last age, rounded up to a
+// second, so that an OOM kill from before the package started isn't taken
+// to be its own. It asks both journalctl and dmesg, since either may be
+// missing or unable to see the kernel log.
+func kernelLogCommand(age time.Duration) string {
+	seconds := int(age/time.Second) + 1
+	return fmt.Sprintf("{ journalctl -k -q --no-pager --since -%ds; dmesg --since -%ds; } 2>/dev/null | tail -n 100",
+		seconds, seconds)
+}
+
+// oomKilled reports whether kernelLog, the kernel log from while a test
+// binary that was killed ran, shows the kernel's OOM killer at work.
+func oomKilled(kernelLog string) bool {
+	return oomEvidence.MatchString(kernelLog)
+}
+
+var failLine = regexp.MustCompile(`(?m)^(--- )?FAIL`)
+
+// passed reports whether the output from go test shows that every test passed.
+func passed(output string) bool {
+	return !failLine.MatchString(output)
+}
+
+// nondeterministic returns the packages, in queue order, that both passed and
+// failed across the runs recorded in outcomes.
+func nondeterministic(queue []string, outcomes map[string][]bool) []string {
+	var flipped []string
+	seen := make(map[string]bool)
+	for _, pkg := range queue {
+		if seen[pkg] {
+			continue
+		}
+		seen[pkg] = true
+		runs := outcomes[pkg]
+		for _, pass := range runs {
+			if pass != runs[0] {
+				flipped = append(flipped, pkg)
+				break
+			}
+		}
+	}
+	return flipped
+}
+
+// countPasses returns how many of the runs in outcomes passed.
+func countPasses(outcomes []bool) int {
+	passes := 0
+	for _, pass := range outcomes {
+		if pass {
+			passes++
+		}
+	}
+	return passes
+}
+
+// resultCollector keeps track of which results are still to come. Each
+// package is expected once for every time it was queued; any result beyond
+// that is a duplicate, which can't be allowed to count towards finishing the
+// run early.
+type resultCollector struct {
+	remaining   map[string]int
+	outstanding int
+	accepted    int
+}
+
+func newResultCollector(queue []string) *resultCollector {
+	c := &resultCollector{remaining: make(map[string]int)}
+	for _, pkg := range queue {
+		c.remaining[pkg]++
+	}
+	c.outstanding = len(queue)
+	return c
 }
 
-// Test a single juju package
-func (r *RemoteWorker) TestPackage(pkg string) string {
+// Skip stops expecting any more results for pkg.
+func (c *resultCollector) Skip(pkg string) {
+	c.outstanding -= c.remaining[pkg]
+	delete(c.remaining, pkg)
+}
 
-	r.remoteCommand("cd ~/dev/go/src/github.com/juju/juju/")
-	r.waitForPrompt()
-	r.remoteCommand("cd " + pkg)
-	r.waitForPrompt()
-	r.remoteCommand("go test -test.timeout=1200s ./...")
-	line := r.waitForPrompt()
-	return line
+// Accept records result and reports whether it was expected. A result for a
+// package that has already had all of its results is not.
+func (c *resultCollector) Accept(result Result) bool {
+	if c.remaining[result.Package] == 0 {
+		return false
+	}
+	c.remaining[result.Package]--
+	c.outstanding--
+	c.accepted++
+	return true
 }
 
-// TestPackages receives names of packages to test on package_chan and returns
-// their output on results_chan. Once there are no more packages to test it
-// closes the SSH connection and signals that it is done on the wait group
-// RemoteWorker.wg
-func (r *RemoteWorker) TestPackages(package_chan, results_chan chan string) {
-	for pkg := range package_chan {
-		results_chan <- r.TestPackage(pkg)
+// expects reports whether any more results are expected for pkg.
+func (c *resultCollector) expects(pkg string) bool {
+	return c.remaining[pkg] > 0
+}
+
+// Done reports whether every expected result has arrived.
+func (c *resultCollector) Done() bool {
+	return c.outstanding == 0
+}
+
+// writeResult writes the output of result to w. With markers it is bracketed
+// by lines that log parsers can find:
+//
+//	>>> BEGIN state on homework1
+//	...
+//	<<< END state status=pass duration=12.345s
+func writeResult(w io.Writer, result Result, markers bool) {
+	if !markers {
+		fmt.Fprint(w, result.Output)
+		return
+	}
+	fmt.Fprintf(w, ">>> BEGIN %s on %s\n", result.Package, result.Worker)
+	fmt.Fprint(w, result.Output)
+	if result.Output != "" && !strings.HasSuffix(result.Output, "\n") {
+		fmt.Fprintln(w)
 	}
-	r.Close()
-	r.wg.Done()
+	fmt.Fprintf(w, "<<< END %s status=%s duration=%.3fs\n",
+		result.Package, result.Status(), result.Duration.Seconds())
+}
+
+// orderedPrinter prints results in the order their packages were queued. A
+// result that completes early is held back until every package queued before
+// it has been printed, so the output is the same from run to run. A package
+// that is queued more than once is given the positions it was queued at in
+// the order its results arrive. Packages that won't be tested are skipped
+// over.
+type orderedPrinter struct {
+	w       io.Writer
+	markers bool
+	order   map[string][]int
+	pending map[int]Result
+	skipped map[int]bool
+	next    int
+}
+
+func newOrderedPrinter(w io.Writer, queue []string, markers bool) *orderedPrinter {
+	p := &orderedPrinter{
+		w:       w,
+		markers: markers,
+		order:   make(map[string][]int, len(queue)),
+		pending: make(map[int]Result),
+		skipped: make(map[int]bool),
+	}
+	for i, pkg := range queue {
+		p.order[pkg] = append(p.order[pkg], i)
+	}
+	return p
+}
+
+// Print holds on to result and then prints every result that is now next in
+// queue order.
+func (p *orderedPrinter) Print(result Result) {
+	positions := p.order[result.Package]
+	if len(positions) == 0 {
+		return
+	}
+	p.order[result.Package] = positions[1:]
+	p.pending[positions[0]] = result
+	p.advance()
+}
+
+// Skip stops waiting for any more results for pkg, so that the results
+// queued after it can be printed.
+func (p *orderedPrinter) Skip(pkg string) {
+	for _, position := range p.order[pkg] {
+		p.skipped[position] = true
+	}
+	delete(p.order, pkg)
+	p.advance()
+}
+
+// advance prints results, and passes over skipped packages, for as long as
+// the next one in queue order is there.
+func (p *orderedPrinter) advance() {
+	for {
+		if p.skipped[p.next] {
+			delete(p.skipped, p.next)
+			p.next++
+			continue
+		}
+		next, ok := p.pending[p.next]
+		if !ok {
+			return
+		}
+		writeResult(p.w, next, p.markers)
+		delete(p.pending, p.next)
+		p.next++
+	}
+}
+
+// Flush prints every result still held back, in queue order, for when the
+// run ends before all of the results have arrived.
+func (p *orderedPrinter) Flush() {
+	var positions []int
+	for position := range p.pending {
+		positions = append(positions, position)
+	}
+	sort.Ints(positions)
+	for _, position := range positions {
+		writeResult(p.w, p.pending[position], p.markers)
+		delete(p.pending, position)
+	}
+}
+
+// readPackageList reads package names, one per line, ignoring blank lines.
+func readPackageList(r io.Reader) ([]string, error) {
+	var packages []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if pkg := strings.TrimSpace(scanner.Text()); pkg != "" {
+			packages = append(packages, pkg)
+		}
+	}
+	return packages, scanner.Err()
+}
+
+// secretURLFlags are the flags whose URLs may carry credentials, in their
+// user info, path or query.
+var secretURLFlags = map[string]bool{"result-webhook": true, "otlp-endpoint": true}
+
+// redactURL returns the scheme and host of a URL taken from a flag, hiding
+// anything after them that could be a credential.
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return redacted
+	}
+	if u.User == nil && (u.Path == "" || u.Path == "/") && u.RawQuery == "" {
+		return value
+	}
+	return u.Scheme + "://" + u.Host + "/" + redacted
+}
+
+// redactStrings applies r to every string in a value decoded from JSON.
+// The -redact patterns themselves are left alone.
+func redactStrings(value interface{}, r redactor) interface{} {
+	switch value := value.(type) {
+	case string:
+		return r.Redact(value)
+	case []interface{}:
+		for i := range value {
+			value[i] = redactStrings(value[i], r)
+		}
+	case map[string]interface{}:
+		for key := range value {
+			if key != "redact" {
+				value[key] = redactStrings(value[key], r)
+			}
+		}
+	}
+	return value
+}
+
+// writeConfig writes the effective configuration to w as JSON: the value of
+// every flag other than -dump-config, the workers, packages and groups read
+// from -inventory and the per-package timeouts. URLs that may hold
+// credentials are masked and everything else goes through the -redact
+// patterns.
+func writeConfig(w io.Writer, inv *inventory, timeouts map[string]time.Duration, redact redactor) error {
+	flags := make(map[string]interface{})
+	flag.VisitAll(func(f *flag.Flag) {
+		switch value := f.Value.(type) {
+		case *stringList:
+			flags[f.Name] = []string(*value)
+		case flag.Getter:
+			if f.Name == "dump-config" {
+				return
+			}
+			if d, ok := value.Get().(time.Duration); ok {
+				flags[f.Name] = d.String()
+			} else if secretURLFlags[f.Name] && f.Value.String() != "" {
+				flags[f.Name] = redactURL(f.Value.String())
+			} else {
+				flags[f.Name] = value.Get()
+			}
+		}
+	})
+	timeoutStrings := make(map[string]string)
+	for pkg, timeout := range timeouts {
+		timeoutStrings[pkg] = timeout.String()
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"flags":     flags,
+		"inventory": inv,
+		"timeouts":  timeoutStrings,
+	})
+	if err != nil {
+		return err
+	}
+	var config interface{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return err
+	}
+	if config, ok := config.(map[string]interface{}); ok {
+		config["flags"] = redactStrings(config["flags"], redact)
+		config["inventory"] = redactStrings(config["inventory"], redact)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(config)
+}
+
+// parseExitCodes parses a comma separated list of non-zero exit statuses.
+func parseExitCodes(value string) (map[int]bool, error) {
+	codes := make(map[int]bool)
+	for _, item := range commaList(value) {
+		code, err := strconv.Atoi(item)
+		if err != nil || code <= 0 || code > 255 {
+			return nil, fmt.Errorf("%q is not a non-zero exit status", item)
+		}
+		codes[code] = true
+	}
+	return codes, nil
+}
+
+// Exit codes. A run where every package was tested and passed exits zero,
+// even if some workers could not be used.
+const (
+	// exitTestsFailed means every package was tested but some failed.
+	exitTestsFailed = 1
+	// exitInfraFailure means some packages could not be tested at all.
+	exitInfraFailure = 3
+)
+
+// runExitCode returns the exit code for a run that queued packages, got
+// results for completed of them and saw failed of those fail. Not testing a
+// package is worse than a test failure, since we can't say whether it passes.
+func runExitCode(queued, completed, failed int) int {
+	switch {
+	case completed < queued:
+		return exitInfraFailure
+	case failed > 0:
+		return exitTestsFailed
+	}
+	return 0
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "control" {
+		runControlClient(os.Args[2:])
+		return
+	}
+	flag.Parse()
+
+	options, err := parseSSHOptions(sshOptionFlags)
+	if err != nil {
+		log.Fatalf("bad -o: %s", err)
+	}
+	options.KnownHostsFiles = knownHostsFlags
+
+	// Dialers are registered first, since the inventory refers to them.
+	if err := parseDialers(dialerFlags); err != nil {
+		log.Fatalf("bad -dialer: %s", err)
+	}
+
+	inv := &inventory{}
+	if *inventoryFile != "" {
+		inv, err = loadInventory(*inventoryFile)
+		if err != nil {
+			log.Fatalf("bad -inventory: %s", err)
+		}
+	}
+
+	aliases, err := parseAliases(aliasFlags)
+	if err != nil {
+		log.Fatalf("bad -alias: %s", err)
+	}
+	for name, group := range inv.aliases() {
+		if _, ok := aliases[name]; !ok {
+			aliases[name] = group
+		}
+	}
+
+	redact, err := parseRedactions(redactFlags)
+	if err != nil {
+		log.Fatalf("bad -redact: %s", err)
+	}
+
+	pins, err := parsePins(pinFlags, aliases)
+	if err != nil {
+		log.Fatalf("bad -pin: %s", err)
+	}
+
+	retryExitCodes, err := parseExitCodes(*retryExitCodesFlag)
+	if err != nil {
+		log.Fatalf("bad -retry-exit-codes: %s", err)
+	}
+
+	if *serial && len(pins) > 0 {
+		log.Fatal("-pin can't be used with -serial")
+	}
+	if *fullRepeat > 1 && *verifyRepro > 1 {
+		log.Fatal("-full-repeat can't be used with -verify-repro")
+	}
+
+	// The baseline is read now so that a bad path stops the run before it
+	// starts, rather than after all the testing.
+	var baseline []benchmark
+	if *benchBaseline != "" {
+		f, err := os.Open(*benchBaseline)
+		if err != nil {
+			log.Fatalf("bad -bench-baseline: %s", err)
+		}
+		baseline = parseBenchmarks("", f)
+		f.Close()
+	}
+
+	timeouts, err := loadTimeouts(*timeoutsFile)
+	if err != nil {
+		log.Fatalf("unable to read timeouts: %s", err)
+	}
+	for pkg, timeout := range inv.packageTimeouts() {
+		if _, ok := timeouts[pkg]; !ok {
+			if timeouts == nil {
+				timeouts = make(map[string]time.Duration)
+			}
+			timeouts[pkg] = timeout
+		}
+	}
+
+	if *dumpConfig {
+		if err := writeConfig(os.Stdout, inv, timeouts, redact); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var worker_names = []string{"homework1", "homework2", "homework4"}
+	if len(inv.Workers) > 0 {
+		worker_names = inv.hosts()
+	}
+
+	if *listWorkers {
+		if err := writeWorkerList(os.Stdout, worker_names, inv, options); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var webhook *resultWebhook
+	if *resultWebhookURL != "" {
+		webhook, err = newResultWebhook(*resultWebhookURL, *resultWebhookTemplate)
+		if err != nil {
+			log.Fatalf("bad -result-webhook-template: %s", err)
+		}
+	}
+
+	var traces *traceExporter
+	if *otlpEndpoint != "" {
+		traces = newTraceExporter(*otlpEndpoint, time.Now())
+	}
+
+	var results_file *os.File
+	if *resultsFD >= 0 {
+		results_file = os.NewFile(uintptr(*resultsFD), "results")
+		if _, err := results_file.Stat(); err != nil {
+			log.Fatalf("bad -results-fd %d: %s", *resultsFD, err)
+		}
+	}
+
+	var json_file *os.File
+	if *jsonOut != "" {
+		json_file, err = os.Create(*jsonOut)
+		if err != nil {
+			log.Fatalf("unable to create -json-out file: %s", err)
+		}
+	}
+
 	var packages = []string{"apiserver", "worker", "cmd", "replicaset",
 		"state", "api", "environs", "provider", "upgrades", "juju",
 		"featuretests", "bzr", "container", "downloader", "testing",
@@ -157,30 +1428,489 @@ func main() {
 		"utils", "rpc", "service", "network", "version", "constraints",
 		"instance", "leadership", "audit", "tools"}
 
-	package_chan := make(chan string, len(packages))
-	results_chan := make(chan string, len(packages))
+	if len(inv.Packages) > 0 {
+		packages = inv.packageNames()
+	}
+	if flag.NArg() > 0 {
+		packages = flag.Args()
+	}
 
-	var wg sync.WaitGroup
+	if *onlyFailedFrom != "" {
+		var in io.Reader = os.Stdin
+		if *onlyFailedFrom != "-" {
+			f, err := os.Open(*onlyFailedFrom)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer f.Close()
+			in = f
+		}
+		packages, err = readPackageList(in)
+		if err != nil {
+			log.Fatalf("unable to read package list: %s", err)
+		}
+	}
 
-	for i := range packages {
-		package_chan <- packages[len(packages)-1-i]
+	if *order != "" {
+		packages = commaList(*order)
 	}
-	close(package_chan)
 
-	var worker_names = []string{"homework1", "homework2", "homework4"}
-	var workers = []RemoteWorker{}
+	packages, err = expandAliases(packages, aliases)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	runs := *verifyRepro
+	if *fullRepeat > 1 {
+		runs = *fullRepeat
+	}
+	if runs < 1 {
+		runs = 1
+	}
+	// Each run is queued after the one before. There is no barrier between
+	// them, though: the packages are split between the unit, integration,
+	// pinned and fixture queues, which workers take from in turn, and one
+	// worker can start on the next run while another is still testing the
+	// last. Outcomes are kept per package, so runs overlapping doesn't
+	// change the pass ratios.
+	var queue []string
+	for run := 0; run < runs; run++ {
+		for i := range packages {
+			if *order != "" {
+				queue = append(queue, packages[i])
+			} else {
+				queue = append(queue, packages[len(packages)-1-i])
+			}
+		}
+	}
+
+	// The retry of a run always has packages to test, since its first
+	// go had, so finding none means they were lost on the way.
+	if len(queue) == 0 && retried() {
+		log.Print("no packages to test in a retried run")
+		os.Exit(exitInfraFailure)
+	}
+
+	unit_chan := make(chan string, len(queue))
+	integration_chan := make(chan string, len(queue))
+	results_chan := make(chan Result, len(queue))
+
+	pinned_chans := make(map[string]chan string)
+	known_workers := stringSet(worker_names)
+	for _, worker := range pins {
+		if !known_workers[worker] {
+			log.Fatalf("packages are pinned to %s, which is not a worker", worker)
+		}
+		if _, ok := pinned_chans[worker]; !ok {
+			pinned_chans[worker] = make(chan string, len(queue))
+		}
+	}
 
+	integration_packages, err := expandAliases(append(commaList(*integrationPackages), inv.integrationPackages()...), aliases)
+	if err != nil {
+		log.Fatalf("bad -integration-packages: %s", err)
+	}
+	integration := stringSet(integration_packages)
+	package_fixtures := inv.packageFixtures()
+	fixture_chans := make(map[string]chan string)
+	for _, key := range package_fixtures {
+		if _, ok := fixture_chans[key]; !ok {
+			fixture_chans[key] = make(chan string, len(queue))
+		}
+	}
+	have_integration := false
+	// With only one worker, routing by type would just reorder the queue.
+	route_by_type := !*serial
+	for _, pkg := range queue {
+		if worker, ok := pins[pkg]; ok {
+			pinned_chans[worker] <- pkg
+		} else if key, ok := package_fixtures[pkg]; ok {
+			fixture_chans[key] <- pkg
+		} else if route_by_type && classifyPackage(pkg, integration) == integrationPackage {
+			integration_chan <- pkg
+			have_integration = true
+		} else {
+			unit_chan <- pkg
+		}
+	}
+	close(unit_chan)
+	close(integration_chan)
+	for _, pinned_chan := range pinned_chans {
+		close(pinned_chan)
+	}
+	for _, fixture_chan := range fixture_chans {
+		close(fixture_chan)
+	}
+
+	incidents := &incidentLog{}
+	test_farm := &farm{
+		newWorker: func(host string) *RemoteWorker {
+			worker_options := options
+			dialer := ""
+			go_version := ""
+			repo_path := defaultRepoPath
+			if w, ok := inv.worker(host); ok {
+				worker_options = w.applyTo(options)
+				dialer = w.Dialer
+				go_version = w.GoVersion
+				if w.RepoPath != "" {
+					repo_path = w.RepoPath
+				}
+			}
+			return &RemoteWorker{
+				shell:          *shell,
+				transcriptDir:  *transcriptDir,
+				options:        worker_options,
+				bench:          *bench,
+				bootstrap:      *bootstrap,
+				tailLines:      *tailLines,
+				maxOutputLines: *maxOutputLines,
+				retryExitCodes: retryExitCodes,
+				maxRetries:     *maxRetries,
+				verbose:        *maxSkips >= 0,
+				timeouts:       timeouts,
+				repoPath:       repo_path,
+				redact:         redact,
+				promptLimit:    *maxPromptBuffer,
+				sentinels:      *sentinelSync,
+				bisectCount:    *bisectFlaky,
+				maxLoad:        *maxLoad,
+				loadBackoff:    *loadBackoff,
+				history:        commandHistory{limit: *recentCommands},
+				dialer:         dialer,
+				goVersion:      go_version,
+				timeBuild:      *otlpEndpoint != "",
+			}
+		},
+		warmup: func(w *RemoteWorker) error {
+			if *readyCmd != "" {
+				if err := w.waitUntilReady(*readyCmd, *readyRetries, *readyTimeout); err != nil {
+					return err
+				}
+			}
+			if w.goVersion != "" {
+				if err := w.checkGoVersion(); err != nil {
+					return err
+				}
+			}
+			if *isolateGoCache {
+				if err := w.isolateGoCache(); err != nil {
+					return err
+				}
+			}
+			if *warnClockSkew > 0 || *maxClockSkew > 0 {
+				if err := w.checkClockSkew(*warnClockSkew, *maxClockSkew, incidents); err != nil {
+					return err
+				}
+			}
+			if *captureEnv {
+				env, err := w.captureEnv()
+				if err != nil {
+					log.Printf("unable to capture environment of %s: %s", w.host, err)
+				}
+				w.env = env
+			}
+			if *modCheck {
+				if err := w.checkModules(); err != nil {
+					return err
+				}
+			}
+			if *modDownload {
+				if err := w.downloadModules(); err != nil {
+					return err
+				}
+			}
+			if *smoke != "" {
+				if err := w.smokeTest(*smoke); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		unitQueue:          unit_chan,
+		integrationQueue:   integration_chan,
+		pinnedQueues:       pinned_chans,
+		fixtureQueues:      fixture_chans,
+		workerFixtures:     inv.workerFixtures(),
+		integrationWorkers: stringSet(append(commaList(*integrationWorkers), inv.integrationWorkers()...)),
+		results:            results_chan,
+		incidents:          incidents,
+		serial:             *serial,
+		stop:               make(chan struct{}),
+		dispatch:           &dispatch{},
+	}
+
+	unready := 0
 	for _, name := range worker_names {
-		w := RemoteWorker{}
-		wg.Add(1)
-		w.Setup(name, &wg)
-		go w.TestPackages(package_chan, results_chan)
-		workers = append(workers, w)
+		if err := test_farm.AddWorker(name); err != nil {
+			log.Printf("excluding %s: %s", name, err)
+			unready++
+		} else if *serial {
+			break
+		}
+	}
+	if massFailure(unready, len(worker_names), *retryRunOnMassFailure) {
+		log.Printf("%d of %d workers aren't ready", unready, len(worker_names))
+		if err := retryRun(*massFailureBackoff, *onlyFailedFrom == "-", test_farm.Kill); err != nil {
+			log.Printf("not retrying: %s", err)
+		}
+	}
+	workers := test_farm.Workers()
+	if len(workers) == 0 {
+		test_farm.incidents.writeSummary(os.Stdout)
+
+		if *captureEnv {
+			writeEnvReport(os.Stdout, test_farm.Envs())
+		}
+		log.Print("no workers are ready")
+		os.Exit(exitInfraFailure)
+	}
+
+	eligible := false
+	for _, w := range workers {
+		eligible = eligible || test_farm.canRunIntegration(w.host)
+	}
+	if have_integration && !eligible {
+		log.Print("there are integration packages but none of -integration-workers are ready")
+		os.Exit(exitInfraFailure)
+	}
+
+	// Packages pinned to a worker that isn't ready can't be tested.
+	collector := newResultCollector(queue)
+	printer := newOrderedPrinter(os.Stdout, queue, *markers)
+	running := make(map[string]bool)
+	for _, w := range workers {
+		running[w.host] = true
+	}
+	for pkg, worker := range pins {
+		if !running[worker] {
+			log.Printf("not testing %s, which is pinned to %s", pkg, worker)
+			collector.Skip(pkg)
+			printer.Skip(pkg)
+		}
+	}
+	// As are packages needing fixtures that no worker has.
+	for pkg, key := range package_fixtures {
+		if _, pinned := pins[pkg]; pinned || !collector.expects(pkg) {
+			continue
+		}
+		equipped := false
+		for _, w := range workers {
+			equipped = equipped || hasFixtures(test_farm.workerFixtures[w.host], key)
+		}
+		if !equipped {
+			log.Printf("not testing %s, which needs fixtures %s that no ready worker has", pkg, key)
+			collector.Skip(pkg)
+			printer.Skip(pkg)
+		}
+	}
+
+	var control net.Listener
+	if *controlSocket != "" {
+		os.Remove(*controlSocket)
+		control, err = net.Listen("unix", *controlSocket)
+		if err != nil {
+			log.Fatalf("unable to listen for control commands: %s", err)
+		}
+		go serveControl(control, test_farm)
+	}
+
+	commit := localCommit()
+
+	outcomes := make(map[string][]bool)
+	var results []Result
+	failed := 0
+	var oom_killed []string
+	var cancelled []string
+	var benchmarks []benchmark
+	// publish passes result on to everything that takes results as they
+	// come in. While the run may yet be retried they are held back, so
+	// that nothing sees the results of a run that is thrown away.
+	may_retry := *retryRunOnMassFailure > 0 && !retried()
+	var held []Result
+	publish := func(result Result) {
+		if webhook != nil {
+			webhook.Post(result)
+		}
+		if results_file != nil {
+			if err := writeResultLine(results_file, result, commit); err != nil {
+				log.Printf("unable to write to -results-fd: %s", err)
+			}
+		}
+		if json_file != nil {
+			if err := writeTestEvents(json_file, result); err != nil {
+				log.Printf("unable to write to -json-out: %s", err)
+			}
+		}
+	}
+	collect := func(result Result) {
+		if !collector.Accept(result) {
+			log.Printf("ignoring duplicate result for %s from %s", result.Package, result.Worker)
+			return
+		}
+		results = append(results, result)
+		if result.Cancelled {
+			cancelled = append(cancelled, result.Package)
+		} else {
+			outcomes[result.Package] = append(outcomes[result.Package], result.Passed)
+			if !result.Passed {
+				failed++
+			}
+		}
+		if result.OOMKilled {
+			oom_killed = append(oom_killed, result.Package)
+			test_farm.incidents.Record(result.Worker, incidentOOMKilled, result.Package)
+		}
+		if traces != nil {
+			traces.Add(result)
+		}
+		if may_retry {
+			held = append(held, result)
+		} else {
+			publish(result)
+		}
+		if *bench != "" {
+			benchmarks = append(benchmarks,
+				parseBenchmarks(result.Package, strings.NewReader(result.Output))...)
+		}
+		if *ordered {
+			printer.Print(result)
+		} else {
+			writeResult(os.Stdout, result, *markers)
+		}
+	}
+
+	workers_done := make(chan struct{})
+	go func() {
+		test_farm.Wait()
+		close(workers_done)
+	}()
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go test_farm.drain(signals, *drainTimeout, workers_done)
+
+	collecting := true
+	for collecting && !collector.Done() {
+		select {
+		case result := <-results_chan:
+			collect(result)
+		case <-workers_done:
+			// Every worker has stopped, so any results still to come
+			// have already been sent.
+			for len(results_chan) > 0 {
+				collect(<-results_chan)
+			}
+			collecting = false
+		}
+	}
+	printer.Flush()
+
+	lost := 0
+	for _, w := range test_farm.Workers() {
+		if w.lost.Load() {
+			test_farm.incidents.Record(w.host, incidentWorkerLost, "lost connection during the run")
+			lost++
+		}
+	}
+	// Losing workers only matters if it left packages untested. The
+	// retry is decided before anything is reported.
+	if lost > 0 && !collector.Done() && massFailure(unready+lost, len(worker_names), *retryRunOnMassFailure) {
+		log.Printf("%d of %d workers weren't ready or were lost", unready+lost, len(worker_names))
+		stop := func() {
+			test_farm.Close()
+			if control != nil {
+				control.Close()
+				os.Remove(*controlSocket)
+			}
+		}
+		if err := retryRun(*massFailureBackoff, *onlyFailedFrom == "-", stop); err != nil {
+			log.Printf("not retrying: %s", err)
+		}
+	}
+	for _, result := range held {
+		publish(result)
+	}
+
+	if *bench != "" {
+		writeBenchReport(os.Stdout, benchmarks, baseline)
+	}
+
+	if *htmlOut != "" {
+		if err := writeHTMLReportFile(*htmlOut, results, commit); err != nil {
+			log.Printf("unable to write HTML report: %s", err)
+		}
 	}
 
-	for got_results := 0; got_results < len(packages); got_results++ {
-		fmt.Print(<-results_chan)
+	for _, pkg := range oom_killed {
+		fmt.Printf("oom-killed: %s\n", pkg)
+	}
+	for _, pkg := range cancelled {
+		fmt.Printf("cancelled: %s\n", pkg)
+	}
+	for _, result := range results {
+		if result.FlakyTest != "" {
+			fmt.Printf("flaky-test: %s %s\n", result.Package, result.FlakyTest)
+		}
+	}
+
+	writeLeakSummary(os.Stdout, results)
+
+	if *warnSlow > 0 {
+		writeSlowSummary(os.Stdout, results, *warnSlow)
+	}
+
+	too_many_skips := false
+	if *maxSkips >= 0 {
+		skips := unexpectedSkips(results, stringSet(allowSkipFlags))
+		too_many_skips = writeSkipSummary(os.Stdout, skips, *maxSkips)
+	}
+
+	if *fullRepeat > 1 {
+		writeStabilityReport(os.Stdout, stability(queue, outcomes))
+	}
+
+	if *verifyRepro > 1 {
+		for _, pkg := range nondeterministic(queue, outcomes) {
+			fmt.Printf("nondeterministic: %s passed %d of %d runs\n",
+				pkg, countPasses(outcomes[pkg]), len(outcomes[pkg]))
+		}
 	}
 
-	wg.Wait()
+	test_farm.Close()
+	if webhook != nil {
+		webhook.Wait()
+	}
+	if traces != nil {
+		if err := traces.Export(time.Now()); err != nil {
+			log.Printf("unable to export trace: %s", err)
+		}
+	}
+	if control != nil {
+		control.Close()
+		os.Remove(*controlSocket)
+	}
+
+	if commit != "" {
+		fmt.Printf("commit: %s\n", commit)
+	}
+	for _, w := range test_farm.Workers() {
+		fmt.Printf("%s: sent %d bytes, received %d bytes\n", w.host, w.sent.n, w.received.n)
+		for _, step := range w.warmupTimes {
+			fmt.Printf("%s: %s took %s\n", w.host, step.Step, step.Duration.Round(time.Millisecond))
+		}
+	}
+	test_farm.incidents.writeSummary(os.Stdout)
+
+	if *captureEnv {
+		writeEnvReport(os.Stdout, test_farm.Envs())
+	}
+
+	// A cancelled package was left out on purpose, so it is neither
+	// expected nor completed.
+	exit_code := runExitCode(len(queue)-len(cancelled), collector.accepted-len(cancelled), failed)
+	if too_many_skips && exit_code == 0 {
+		exit_code = exitTestsFailed
+	}
+	os.Exit(exit_code)
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultTestTimeout is the go test timeout for packages without one of their
+// own.
+const defaultTestTimeout = 1200 * time.Second
+
+// defaultTimeoutsFile is read for per-package timeouts, if it exists, when
+// -timeouts isn't given.
+const defaultTimeoutsFile = "testfarm.timeouts"
+
+// readTimeouts reads per-package go test timeouts, one package and duration
+// per line. Blank lines and lines starting with # are ignored:
+//
+//	# These need longer than the default.
+//	featuretests 40m
+//	state        30m
+func readTimeouts(r io.Reader) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: want a package and a timeout, got %q", line, text)
+		}
+		timeout, err := time.ParseDuration(fields[1])
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("line %d: bad timeout %q", line, fields[1])
+		}
+		timeouts[fields[0]] = timeout
+	}
+	return timeouts, scanner.Err()
+}
+
+// loadTimeouts reads the per-package timeouts in the file path. With path
+// empty it reads defaultTimeoutsFile, if there is one.
+func loadTimeouts(path string) (map[string]time.Duration, error) {
+	optional := path == ""
+	if optional {
+		path = defaultTimeoutsFile
+	}
+	f, err := os.Open(path)
+	if optional && os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	timeouts, err := readTimeouts(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return timeouts, nil
+}
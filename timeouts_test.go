@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadTimeouts(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]time.Duration
+		err   string
+	}{{
+		name:  "timeouts",
+		input: "# These need longer than the default.\nfeaturetests 40m\n\n  state        30m  \n",
+		want:  map[string]time.Duration{"featuretests": 40 * time.Minute, "state": 30 * time.Minute},
+	}, {
+		name:  "empty",
+		input: "",
+		want:  map[string]time.Duration{},
+	}, {
+		name:  "no timeout",
+		input: "featuretests 40m\nstate\n",
+		err:   `line 2: want a package and a timeout, got "state"`,
+	}, {
+		name:  "bad timeout",
+		input: "state 30",
+		err:   `line 1: bad timeout "30"`,
+	}, {
+		name:  "negative timeout",
+		input: "state -30m",
+		err:   `line 1: bad timeout "-30m"`,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := readTimeouts(strings.NewReader(test.input))
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("readTimeouts() error = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("readTimeouts() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLoadTimeouts(t *testing.T) {
+	t.Chdir(t.TempDir())
+	// Without -timeouts a missing default file is fine.
+	if timeouts, err := loadTimeouts(""); timeouts != nil || err != nil {
+		t.Errorf("loadTimeouts(\"\") = %v, %v without %s", timeouts, err, defaultTimeoutsFile)
+	}
+	if err := os.WriteFile(defaultTimeoutsFile, []byte("state 30m\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	timeouts, err := loadTimeouts("")
+	if err != nil || timeouts["state"] != 30*time.Minute {
+		t.Errorf("loadTimeouts(\"\") = %v, %v, want %s read", timeouts, err, defaultTimeoutsFile)
+	}
+
+	if _, err := loadTimeouts(filepath.Join("missing", "timeouts")); err == nil {
+		t.Errorf("loadTimeouts() didn't fail for a missing -timeouts file")
+	}
+	if err := os.WriteFile("bad.timeouts", []byte("state\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadTimeouts("bad.timeouts"); err == nil || !strings.HasPrefix(err.Error(), "bad.timeouts: line 1: ") {
+		t.Errorf("loadTimeouts() error = %v, want it to name the file", err)
+	}
+}
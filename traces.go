@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// traceExporter sends a trace of the run to an OpenTelemetry collector when
+// the run finishes. The run is the root span, with a span for each package
+// and a child of that for each go test attempt. The spans are posted in the
+// OTLP/HTTP JSON encoding, so no OpenTelemetry SDK is needed.
+type traceExporter struct {
+	url     string
+	client  *http.Client
+	traceID string
+	root    otlpSpan
+
+	mu    sync.Mutex
+	spans []otlpSpan
+}
+
+// otlpSpan is a span in the OTLP JSON encoding. IDs are hex and times are
+// nanoseconds since the epoch, as decimal strings.
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	Status       otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	String *string  `json:"stringValue,omitempty"`
+	Int    *string  `json:"intValue,omitempty"`
+	Double *float64 `json:"doubleValue,omitempty"`
+	Bool   *bool    `json:"boolValue,omitempty"`
+}
+
+type otlpStatus struct {
+	Code int `json:"code"`
+}
+
+// OTLP span kinds and status codes.
+const (
+	otlpKindInternal = 1
+	otlpStatusOK     = 1
+	otlpStatusError  = 2
+)
+
+func stringAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{key, otlpValue{String: &value}}
+}
+
+func intAttribute(key string, value int) otlpAttribute {
+	s := strconv.Itoa(value)
+	return otlpAttribute{key, otlpValue{Int: &s}}
+}
+
+func doubleAttribute(key string, value float64) otlpAttribute {
+	return otlpAttribute{key, otlpValue{Double: &value}}
+}
+
+func boolAttribute(key string, value bool) otlpAttribute {
+	return otlpAttribute{key, otlpValue{Bool: &value}}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// randomID returns n random bytes in hex.
+func randomID(n int) string {
+	id := make([]byte, n)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// newTraceExporter starts a trace of a run that began at start, to be posted
+// to url.
+func newTraceExporter(url string, start time.Time) *traceExporter {
+	traceID := randomID(16)
+	return &traceExporter{
+		url:     url,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		traceID: traceID,
+		root: otlpSpan{
+			TraceID: traceID,
+			SpanID:  randomID(8),
+			Name:    "test_farm run",
+			Kind:    otlpKindInternal,
+			Start:   unixNano(start),
+		},
+	}
+}
+
+// Add records the spans for result. A package that was cancelled before it
+// was tested has none.
+func (e *traceExporter) Add(result Result) {
+	if result.Started.IsZero() {
+		return
+	}
+	spans := e.resultSpans(result)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+}
+
+// resultSpans returns the span for result's package followed by a span for
+// each of its attempts. An attempt whose build was timed has a build and a
+// test span under it.
+func (e *traceExporter) resultSpans(result Result) []otlpSpan {
+	status := otlpStatusOK
+	if !result.Passed {
+		status = otlpStatusError
+	}
+	end := result.Started
+	if n := len(result.AttemptTimes); n > 0 {
+		last := result.AttemptTimes[n-1]
+		end = last.Start.Add(last.Duration)
+	}
+	pkg := otlpSpan{
+		TraceID:      e.traceID,
+		SpanID:       randomID(8),
+		ParentSpanID: e.root.SpanID,
+		Name:         result.Package,
+		Kind:         otlpKindInternal,
+		Start:        unixNano(result.Started),
+		End:          unixNano(end),
+		Attributes: []otlpAttribute{
+			stringAttribute("testfarm.package", result.Package),
+			stringAttribute("testfarm.worker", result.Worker),
+			stringAttribute("testfarm.status", result.Status()),
+			doubleAttribute("testfarm.duration_seconds", end.Sub(result.Started).Seconds()),
+			intAttribute("testfarm.attempts", result.Attempts),
+			intAttribute("testfarm.exit_code", result.ExitCode),
+			boolAttribute("testfarm.oom_killed", result.OOMKilled),
+		},
+		Status: otlpStatus{status},
+	}
+	spans := []otlpSpan{pkg}
+	for i, attempt := range result.AttemptTimes {
+		status := otlpStatusOK
+		if attempt.ExitCode != 0 {
+			status = otlpStatusError
+		}
+		end := attempt.Start.Add(attempt.Duration)
+		span := otlpSpan{
+			TraceID:      e.traceID,
+			SpanID:       randomID(8),
+			ParentSpanID: pkg.SpanID,
+			Name:         "go test",
+			Kind:         otlpKindInternal,
+			Start:        unixNano(attempt.Start),
+			End:          unixNano(end),
+			Attributes: []otlpAttribute{
+				intAttribute("testfarm.attempt", i+1),
+				intAttribute("testfarm.exit_code", attempt.ExitCode),
+			},
+			Status: otlpStatus{status},
+		}
+		spans = append(spans, span)
+		if attempt.Build > 0 {
+			built := attempt.Start.Add(attempt.Build)
+			spans = append(spans,
+				e.phaseSpan(span, "build", attempt.Start, built, otlpStatusOK),
+				e.phaseSpan(span, "test", built, end, status))
+		}
+	}
+	return spans
+}
+
+// phaseSpan returns a span for part of the attempt in parent.
+func (e *traceExporter) phaseSpan(parent otlpSpan, name string, start, end time.Time, status int) otlpSpan {
+	return otlpSpan{
+		TraceID:      e.traceID,
+		SpanID:       randomID(8),
+		ParentSpanID: parent.SpanID,
+		Name:         name,
+		Kind:         otlpKindInternal,
+		Start:        unixNano(start),
+		End:          unixNano(end),
+		Status:       otlpStatus{status},
+	}
+}
+
+// payload returns the OTLP JSON request holding every span, with the run
+// ending at end.
+func (e *traceExporter) payload(end time.Time) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	root := e.root
+	root.End = unixNano(end)
+	root.Status = otlpStatus{otlpStatusOK}
+	spans := append([]otlpSpan{root}, e.spans...)
+	return json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpAttribute{stringAttribute("service.name", "test_farm")},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "test_farm"},
+				"spans": spans,
+			}},
+		}},
+	})
+}
+
+// Export posts the trace, with the run ending at end.
+func (e *traceExporter) Export(end time.Time) error {
+	payload, err := e.payload(end)
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", e.url, resp.Status)
+	}
+	return nil
+}
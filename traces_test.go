@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// attributeMap returns span's attributes by key, as their JSON values.
+func attributeMap(t *testing.T, span otlpSpan) map[string]string {
+	attributes := make(map[string]string)
+	for _, attribute := range span.Attributes {
+		value, err := json.Marshal(attribute.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		attributes[attribute.Key] = string(value)
+	}
+	return attributes
+}
+
+func TestTraceExport(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("posted %s", req.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(req.Body)
+		bodies <- body
+	}))
+	defer server.Close()
+
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	e := newTraceExporter(server.URL, start)
+	e.Add(Result{
+		Package: "state", Worker: "homework1", Passed: true, Attempts: 1,
+		Started:      start.Add(time.Second),
+		AttemptTimes: []attemptTime{{start.Add(time.Second), 2 * time.Second, 0, 0}},
+	})
+	e.Add(Result{
+		Package: "api", Worker: "homework2", Attempts: 2, ExitCode: 1,
+		Started: start.Add(time.Second),
+		AttemptTimes: []attemptTime{
+			{start.Add(time.Second), time.Second, 2, 0},
+			// The second attempt's build was timed.
+			{start.Add(3 * time.Second), time.Second, 1, 400 * time.Millisecond},
+		},
+	})
+	// cmd was cancelled before it was tested.
+	e.Add(Result{Package: "cmd", Cancelled: true})
+	if err := e.Export(start.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	posted := <-bodies
+	var request struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []otlpSpan
+			}
+		}
+	}
+	if err := json.Unmarshal(posted, &request); err != nil {
+		t.Fatalf("bad payload %s: %s", posted, err)
+	}
+	if len(request.ResourceSpans) != 1 || len(request.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("bad payload %s", posted)
+	}
+	spans := request.ResourceSpans[0].ScopeSpans[0].Spans
+	type span struct {
+		name   string
+		parent string
+		start  string
+		end    string
+		status int
+	}
+	var got []span
+	names := make(map[string]string)
+	for _, s := range spans {
+		if s.TraceID != e.traceID {
+			t.Errorf("%s is in trace %s, want %s", s.Name, s.TraceID, e.traceID)
+		}
+		names[s.SpanID] = s.Name
+		got = append(got, span{s.Name, names[s.ParentSpanID], s.Start, s.End, s.Status.Code})
+	}
+	want := []span{
+		{"test_farm run", "", unixNano(start), unixNano(start.Add(time.Minute)), otlpStatusOK},
+		{"state", "test_farm run", unixNano(start.Add(time.Second)), unixNano(start.Add(3 * time.Second)), otlpStatusOK},
+		{"go test", "state", unixNano(start.Add(time.Second)), unixNano(start.Add(3 * time.Second)), otlpStatusOK},
+		{"api", "test_farm run", unixNano(start.Add(time.Second)), unixNano(start.Add(4 * time.Second)), otlpStatusError},
+		{"go test", "api", unixNano(start.Add(time.Second)), unixNano(start.Add(2 * time.Second)), otlpStatusError},
+		{"go test", "api", unixNano(start.Add(3 * time.Second)), unixNano(start.Add(4 * time.Second)), otlpStatusError},
+		{"build", "go test", unixNano(start.Add(3 * time.Second)), unixNano(start.Add(3400 * time.Millisecond)), otlpStatusOK},
+		{"test", "go test", unixNano(start.Add(3400 * time.Millisecond)), unixNano(start.Add(4 * time.Second)), otlpStatusError},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("spans are %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("span %d is %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	attributes := attributeMap(t, spans[3])
+	for key, value := range map[string]string{
+		"testfarm.package":          `{"stringValue":"api"}`,
+		"testfarm.worker":           `{"stringValue":"homework2"}`,
+		"testfarm.status":           `{"stringValue":"fail"}`,
+		"testfarm.duration_seconds": `{"doubleValue":3}`,
+		"testfarm.attempts":         `{"intValue":"2"}`,
+		"testfarm.exit_code":        `{"intValue":"1"}`,
+		"testfarm.oom_killed":       `{"boolValue":false}`,
+	} {
+		if attributes[key] != value {
+			t.Errorf("api span %s = %s, want %s", key, attributes[key], value)
+		}
+	}
+	if attributes := attributeMap(t, spans[5]); attributes["testfarm.attempt"] != `{"intValue":"2"}` {
+		t.Errorf("second attempt span has attributes %v", attributes)
+	}
+}
+
+func TestTraceExportFails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "no", http.StatusForbidden)
+	}))
+	defer server.Close()
+	e := newTraceExporter(server.URL, time.Now())
+	if err := e.Export(time.Now()); err == nil || err.Error() != server.URL+": 403 Forbidden" {
+		t.Errorf("Export() = %v", err)
+	}
+}
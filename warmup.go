@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bootstrapPath is where the -bootstrap script is uploaded to, relative to
+// the remote user's home directory.
+const bootstrapPath = ".testfarm-bootstrap.sh"
+
+// warmupTime is how long a timed warmup step took on a worker.
+type warmupTime struct {
+	Step     string
+	Duration time.Duration
+}
+
+// readyRetryDelay is how long to wait between failed readiness checks.
+var readyRetryDelay = 5 * time.Second
+
+// waitUntilReady runs the readiness check command on the worker until it
+// exits zero, trying up to attempts times. Each attempt is killed on the
+// worker if it runs for longer than timeout.
+func (r *RemoteWorker) waitUntilReady(command string, attempts int, timeout time.Duration) error {
+	seconds := int(timeout / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	check := fmt.Sprintf("timeout %ds sh -c %s", seconds, shellQuote(command))
+	for attempt := 1; ; attempt++ {
+		output, status := r.runCommand(check)
+		if status == 0 {
+			return nil
+		}
+		if attempt >= attempts {
+			return fmt.Errorf("readiness check %q failed %d times, last with status %d: %s",
+				r.redact.Redact(command), attempt, status, strings.TrimSpace(output))
+		}
+		time.Sleep(readyRetryDelay)
+	}
+}
+
+// uploadFile copies the local file to remote on the worker over a separate
+// session, so that it doesn't disturb the shell. What it sends is counted
+// with the rest of the worker's traffic.
+func (r *RemoteWorker) uploadFile(local, remote string) error {
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	session, err := r.conn.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := session.Start("cat > " + shellQuote(remote)); err != nil {
+		return err
+	}
+	n, err := io.Copy(stdin, f)
+	r.sent.add(n)
+	stdin.Close()
+	if err != nil {
+		return err
+	}
+	return session.Wait()
+}
+
+// sourceCommand is the command that runs the script at path in the current
+// shell, so that everything run after it inherits its environment.
+func sourceCommand(path string) string {
+	return ". ~/" + shellQuote(path)
+}
+
+// smokeTest tests pkg on the worker, returning an error if it fails.
+func (r *RemoteWorker) smokeTest(pkg string) error {
+	if output, status := r.TestPackage(pkg); !passed(output) || status > 0 {
+		return fmt.Errorf("smoke test of %s failed:\n%s", pkg, output)
+	}
+	return nil
+}
+
+// checkGoVersion returns an error if go on the worker isn't
+// RemoteWorker.goVersion.
+func (r *RemoteWorker) checkGoVersion() error {
+	output, status := r.runCommand("go env GOVERSION")
+	if status != 0 {
+		return fmt.Errorf("go env GOVERSION failed with status %d: %s", status, strings.TrimSpace(output))
+	}
+	if version := lastLine(output); version != r.goVersion {
+		return fmt.Errorf("go version is %s, not %s as the inventory requires", version, r.goVersion)
+	}
+	return nil
+}
+
+// clockSkew returns how far the worker's clock is ahead of ours.
+func (r *RemoteWorker) clockSkew() (time.Duration, error) {
+	before := time.Now()
+	output, status := r.runCommand("date +%s")
+	after := time.Now()
+	if status != 0 {
+		return 0, fmt.Errorf("date failed with status %d: %s", status, strings.TrimSpace(output))
+	}
+	fields := strings.Fields(output)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("date printed nothing")
+	}
+	seconds, err := strconv.ParseInt(fields[len(fields)-1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected output from date: %q", output)
+	}
+	return computeSkew(time.Unix(seconds, 0), before, after), nil
+}
+
+// computeSkew returns how far remote, a whole number of seconds read from a
+// remote clock some time between before and after, is ahead of the local
+// clock. The remote time is compared with the middle of that window and,
+// since it was truncated to the second, taken to be half a second later
+// than it reads.
+func computeSkew(remote, before, after time.Time) time.Duration {
+	local := before.Add(after.Sub(before) / 2)
+	return remote.Add(time.Second / 2).Sub(local)
+}
+
+// checkClockSkew measures the worker's clock skew. It returns an error if it
+// is more than max, or logs a warning if it is more than warn. A zero limit
+// is not checked.
+func (r *RemoteWorker) checkClockSkew(warn, max time.Duration, incidents *incidentLog) error {
+	skew, err := r.clockSkew()
+	if err != nil {
+		return fmt.Errorf("unable to check clock: %s", err)
+	}
+	abs := skew
+	if abs < 0 {
+		abs = -abs
+	}
+	if max > 0 && abs > max {
+		return fmt.Errorf("clock is %s out, more than -max-clock-skew %s", skew, max)
+	}
+	if warn > 0 && abs > warn {
+		log.Printf("warning: clock on %s is %s out", r.host, skew)
+		incidents.Record(r.host, incidentClockSkew, skew.String())
+	}
+	return nil
+}
+
+// downloadModules runs go mod download in the repository, so that packages
+// tested in parallel later don't all try to download the same modules. How
+// long it took is recorded in RemoteWorker.warmupTimes.
+func (r *RemoteWorker) downloadModules() error {
+	start := time.Now()
+	output, status := r.runCommand("cd " + r.repoPath + " && go mod download")
+	r.warmupTimes = append(r.warmupTimes, warmupTime{"go mod download", time.Since(start)})
+	if status != 0 {
+		return fmt.Errorf("go mod download failed with status %d: %s", status, strings.TrimSpace(output))
+	}
+	return nil
+}
+
+// checkModules runs go mod verify and go mod tidy -diff in the repository, so
+// that a checkout whose modules are inconsistent is found before any package
+// is tested on it.
+func (r *RemoteWorker) checkModules() error {
+	start := time.Now()
+	output, status := r.runCommand("cd " + r.repoPath + " && go mod verify")
+	if err := modVerifyError(output, status); err != nil {
+		return err
+	}
+	output, status = r.runCommand("cd " + r.repoPath + " && go mod tidy -diff")
+	r.warmupTimes = append(r.warmupTimes, warmupTime{"go mod verify and tidy", time.Since(start)})
+	return modTidyError(output, status)
+}
+
+// modVerifyError interprets the output and exit status of go mod verify. It
+// returns nil if every module verified, or an error listing the ones that
+// didn't.
+func modVerifyError(output string, status int) error {
+	output = strings.TrimSpace(output)
+	if status == 0 && strings.Contains(output, "all modules verified") {
+		return nil
+	}
+	return fmt.Errorf("go mod verify failed with status %d: %s", status, output)
+}
+
+// modTidyError interprets the output and exit status of go mod tidy -diff,
+// which exits non-zero and prints the changes tidying would make if go.mod or
+// go.sum isn't tidy. It returns nil if they are.
+func modTidyError(output string, status int) error {
+	output = strings.TrimSpace(output)
+	if status == 0 {
+		return nil
+	}
+	var changes []string
+	for _, line := range strings.Split(output, "\n") {
+		if (strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-")) &&
+			!strings.HasPrefix(line, "+++") && !strings.HasPrefix(line, "---") {
+			changes = append(changes, line)
+		}
+	}
+	if len(changes) == 0 {
+		return fmt.Errorf("go mod tidy -diff failed with status %d: %s", status, output)
+	}
+	return fmt.Errorf("go.mod and go.sum aren't tidy; go mod tidy would change: %s", strings.Join(changes, ", "))
+}
+
+// isolateGoCache points the worker's shell at a new temporary module cache
+// and build cache for this run, so that runs sharing the worker don't
+// interfere with each other. GOPATH itself is left alone, since the
+// repository may be in it. Close removes the caches.
+func (r *RemoteWorker) isolateGoCache() error {
+	output, status := r.runCommand("mktemp -d -t testfarm.XXXXXXXX")
+	fields := strings.Fields(output)
+	if status != 0 || len(fields) == 0 {
+		return fmt.Errorf("unable to make a temporary directory: %s", strings.TrimSpace(output))
+	}
+	dir := fields[len(fields)-1]
+	if output, status := r.runCommand(goCacheEnvCommand(dir)); status != 0 {
+		return fmt.Errorf("unable to set up temporary go caches: %s", strings.TrimSpace(output))
+	}
+	r.goCacheDir = dir
+	return nil
+}
+
+// goCacheEnvCommand is the command that sets up the temporary go caches in
+// dir.
+func goCacheEnvCommand(dir string) string {
+	return fmt.Sprintf("export GOMODCACHE=%s GOCACHE=%s",
+		shellQuote(dir+"/mod"), shellQuote(dir+"/build"))
+}
+
+// goCacheCleanupCommand is the command that removes the temporary go caches
+// in dir. The module cache is read only, so it is made writable first.
+func goCacheCleanupCommand(dir string) string {
+	return fmt.Sprintf("chmod -R u+w %[1]s; rm -rf %[1]s", shellQuote(dir))
+}
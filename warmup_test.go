@@ -0,0 +1,354 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWaitUntilReady(t *testing.T) {
+	defer func(delay time.Duration) { readyRetryDelay = delay }(readyRetryDelay)
+	readyRetryDelay = time.Millisecond
+
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+		tries    int
+		err      string
+	}{
+		{"ready at once", []int{0}, 3, 1, ""},
+		{"ready on the last try", []int{1, 124, 0}, 3, 3, ""},
+		{"never ready", []int{1, 1, 124}, 3, 3,
+			`readiness check "systemctl is-active mongod" failed 3 times, last with status 124: not ready`},
+		{"one attempt", []int{1}, 1, 1,
+			`readiness check "systemctl is-active mongod" failed 1 times, last with status 1: not ready`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tries := 0
+			shell := newFakeShell(func(command string) (string, int) {
+				status := test.statuses[tries]
+				tries++
+				if status != 0 {
+					return "not ready\n", status
+				}
+				return "active\n", 0
+			})
+			r := newFakeWorker(t, shell)
+			err := r.waitUntilReady("systemctl is-active mongod", test.attempts, 30*time.Second)
+			if test.err == "" && err != nil {
+				t.Errorf("waitUntilReady() = %q, want nil", err)
+			}
+			if test.err != "" && (err == nil || err.Error() != test.err) {
+				t.Errorf("waitUntilReady() = %v, want %q", err, test.err)
+			}
+			want := make([]string, test.tries)
+			for i := range want {
+				want[i] = "timeout 30s sh -c 'systemctl is-active mongod'"
+			}
+			if got := shell.Commands(); !reflect.DeepEqual(got, want) {
+				t.Errorf("ran %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestWaitUntilReadyRoundsUpTheTimeout(t *testing.T) {
+	shell := newFakeShell(nil)
+	r := newFakeWorker(t, shell)
+	if err := r.waitUntilReady("true", 1, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if got := shell.Commands(); len(got) != 1 || !strings.HasPrefix(got[0], "timeout 1s ") {
+		t.Errorf("ran %q, want a 1s timeout", got)
+	}
+}
+
+func TestSourceCommand(t *testing.T) {
+	if got, want := sourceCommand(bootstrapPath), ". ~/'.testfarm-bootstrap.sh'"; got != want {
+		t.Errorf("sourceCommand() = %q, want %q", got, want)
+	}
+}
+
+func TestSetupUploadsAndSourcesBootstrap(t *testing.T) {
+	script := "export JUJU_MONGOD=/usr/bin/mongod\nulimit -n 8192\n"
+	tests := []struct {
+		name   string
+		status int
+		err    string
+	}{
+		{"sourced", 0, ""},
+		{"fails", 1, "bootstrap script failed with status 1: ulimit: permission denied"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			startFakeAgent(t)
+			path := filepath.Join(t.TempDir(), "bootstrap.sh")
+			if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+				t.Fatal(err)
+			}
+			shell := newFakeShell(func(command string) (string, int) {
+				if test.status != 0 {
+					return "ulimit: permission denied\n", test.status
+				}
+				return "", 0
+			})
+			server := startFakeServer(t, "127.0.0.1", shell)
+			w := server.worker()
+			w.bootstrap = path
+
+			err := w.Setup(server.host, &sync.WaitGroup{})
+			defer w.Close()
+			if test.err == "" && err != nil {
+				t.Fatalf("Setup() = %q", err)
+			}
+			if test.err != "" && (err == nil || err.Error() != test.err) {
+				t.Fatalf("Setup() = %v, want %q", err, test.err)
+			}
+			// The script is uploaded before anything is run in the shell,
+			// and then sourced first.
+			if got := server.Files()[bootstrapPath]; got != script {
+				t.Errorf("uploaded %q, want %q", got, script)
+			}
+			if got, want := shell.Commands(), []string{sourceCommand(bootstrapPath)}; !reflect.DeepEqual(got, want) {
+				t.Errorf("ran %q, want %q", got, want)
+			}
+			if w.sent.n < int64(len(script)) {
+				t.Errorf("counted %d bytes sent, less than the %d byte script", w.sent.n, len(script))
+			}
+		})
+	}
+}
+
+func TestComputeSkew(t *testing.T) {
+	before := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		remote time.Time
+		after  time.Time
+		want   time.Duration
+	}{
+		{"in step", before, before.Add(time.Second), 0},
+		{"ahead", before.Add(10 * time.Second), before, 10*time.Second + 500*time.Millisecond},
+		{"behind", before.Add(-time.Minute), before.Add(200 * time.Millisecond),
+			-time.Minute + 400*time.Millisecond},
+	}
+	for _, test := range tests {
+		if got := computeSkew(test.remote, before, test.after); got != test.want {
+			t.Errorf("%s: computeSkew() = %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCheckClockSkew(t *testing.T) {
+	tests := []struct {
+		name     string
+		skew     time.Duration
+		warn     time.Duration
+		max      time.Duration
+		warned   bool
+		excluded bool
+	}{
+		{"in step", 0, 5 * time.Second, 30 * time.Second, false, false},
+		{"warned", 10 * time.Second, 5 * time.Second, 30 * time.Second, true, false},
+		{"behind", -10 * time.Second, 5 * time.Second, 0, true, false},
+		{"excluded", time.Minute, 5 * time.Second, 30 * time.Second, false, true},
+		{"no warning", time.Minute, 0, 0, false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			shell := newFakeShell(func(command string) (string, int) {
+				return strconv.FormatInt(time.Now().Add(test.skew).Unix(), 10) + "\n", 0
+			})
+			r := newFakeWorker(t, shell)
+			incidents := &incidentLog{}
+			err := r.checkClockSkew(test.warn, test.max, incidents)
+			if excluded := err != nil; excluded != test.excluded {
+				t.Errorf("checkClockSkew() = %v, want excluded %t", err, test.excluded)
+			}
+			if warned := len(incidents.Incidents()) > 0; warned != test.warned {
+				t.Errorf("incidents are %+v, want warned %t", incidents.Incidents(), test.warned)
+			}
+		})
+	}
+}
+
+func TestClockSkewBadOutput(t *testing.T) {
+	r := newFakeWorker(t, newFakeShell(func(string) (string, int) { return "date: invalid date\n", 0 }))
+	if _, err := r.clockSkew(); err == nil {
+		t.Errorf("clockSkew() didn't fail for output that isn't a number of seconds")
+	}
+}
+
+func TestCheckGoVersion(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		status int
+		err    string
+	}{
+		{"matches", "go1.22.1\n", 0, ""},
+		{"differs", "go1.21.0\n", 0, "go version is go1.21.0, not go1.22.1 as the inventory requires"},
+		{"no go", "sh: 1: go: not found\n", 127, "go env GOVERSION failed with status 127: sh: 1: go: not found"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newFakeWorker(t, newFakeShell(func(string) (string, int) { return test.output, test.status }))
+			r.goVersion = "go1.22.1"
+			err := r.checkGoVersion()
+			if test.err == "" && err != nil {
+				t.Fatalf("checkGoVersion() = %q", err)
+			}
+			if test.err != "" && (err == nil || err.Error() != test.err) {
+				t.Fatalf("checkGoVersion() = %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestDownloadModules(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		err    string
+	}{
+		{"downloaded", 0, ""},
+		{"fails", 1, "go mod download failed with status 1: go: github.com/juju/errors@v1.0.0: reading https://proxy.golang.org: 503"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			shell := newFakeShell(func(string) (string, int) {
+				if test.status != 0 {
+					return "go: github.com/juju/errors@v1.0.0: reading https://proxy.golang.org: 503\n", test.status
+				}
+				return "", 0
+			})
+			r := newFakeWorker(t, shell)
+			err := r.downloadModules()
+			if test.err == "" && err != nil {
+				t.Fatalf("downloadModules() = %q", err)
+			}
+			if test.err != "" && (err == nil || err.Error() != test.err) {
+				t.Fatalf("downloadModules() = %v, want %q", err, test.err)
+			}
+			if got, want := shell.Commands(), []string{"cd " + defaultRepoPath + " && go mod download"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("ran %q, want %q", got, want)
+			}
+			// How long it took is recorded whether or not it worked.
+			if len(r.warmupTimes) != 1 || r.warmupTimes[0].Step != "go mod download" {
+				t.Errorf("warmup times are %+v, want go mod download", r.warmupTimes)
+			}
+		})
+	}
+}
+
+func TestModVerifyError(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		status int
+		err    string
+	}{
+		{"verified", "all modules verified\n", 0, ""},
+		{"modified", "github.com/juju/errors v1.0.0: dir has been modified (/home/ci/go/pkg/mod/github.com/juju/errors@v1.0.0)\n", 1,
+			"go mod verify failed with status 1: github.com/juju/errors v1.0.0: dir has been modified (/home/ci/go/pkg/mod/github.com/juju/errors@v1.0.0)"},
+		{"nothing printed", "", 0, "go mod verify failed with status 0: "},
+	}
+	for _, test := range tests {
+		err := modVerifyError(test.output, test.status)
+		if test.err == "" && err != nil {
+			t.Errorf("%s: modVerifyError() = %q", test.name, err)
+		}
+		if test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("%s: modVerifyError() = %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestModTidyError(t *testing.T) {
+	diff := `diff current/go.mod tidy/go.mod
+--- current/go.mod
++++ tidy/go.mod
+@@ -5,3 +5,3 @@
+ require (
+-	github.com/juju/errors v0.0.0-20220331221717-b38fca44723b
++	github.com/juju/errors v1.0.0
+ )
+`
+	tests := []struct {
+		name   string
+		output string
+		status int
+		err    string
+	}{
+		{"tidy", "", 0, ""},
+		{"untidy", diff, 1, "go.mod and go.sum aren't tidy; go mod tidy would change: " +
+			"-\tgithub.com/juju/errors v0.0.0-20220331221717-b38fca44723b, +\tgithub.com/juju/errors v1.0.0"},
+		{"old go", "flag provided but not defined: -diff\n", 2,
+			"go mod tidy -diff failed with status 2: flag provided but not defined: -diff"},
+	}
+	for _, test := range tests {
+		err := modTidyError(test.output, test.status)
+		if test.err == "" && err != nil {
+			t.Errorf("%s: modTidyError() = %q", test.name, err)
+		}
+		if test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("%s: modTidyError() = %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestGoCacheCommands(t *testing.T) {
+	if got, want := goCacheEnvCommand("/tmp/testfarm.x1"), "export GOMODCACHE='/tmp/testfarm.x1/mod' GOCACHE='/tmp/testfarm.x1/build'"; got != want {
+		t.Errorf("goCacheEnvCommand() = %q, want %q", got, want)
+	}
+	if got, want := goCacheCleanupCommand("/tmp/testfarm.x1"), "chmod -R u+w '/tmp/testfarm.x1'; rm -rf '/tmp/testfarm.x1'"; got != want {
+		t.Errorf("goCacheCleanupCommand() = %q, want %q", got, want)
+	}
+}
+
+func TestIsolateGoCache(t *testing.T) {
+	startFakeAgent(t)
+	shell := newFakeShell(func(command string) (string, int) {
+		if strings.HasPrefix(command, "mktemp") {
+			return "/tmp/testfarm.Ab3dEf9h\n", 0
+		}
+		return "", 0
+	})
+	server := startFakeServer(t, "127.0.0.2", shell)
+	w := server.worker()
+	if err := w.Setup(server.host, &sync.WaitGroup{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.isolateGoCache(); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	want := []string{
+		"mktemp -d -t testfarm.XXXXXXXX",
+		goCacheEnvCommand("/tmp/testfarm.Ab3dEf9h"),
+		goCacheCleanupCommand("/tmp/testfarm.Ab3dEf9h"),
+	}
+	if got := shell.Commands(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ran %q, want %q", got, want)
+	}
+}
+
+func TestIsolateGoCacheFails(t *testing.T) {
+	r := newFakeWorker(t, newFakeShell(func(string) (string, int) {
+		return "mktemp: failed to create directory via template: No space left on device\n", 1
+	}))
+	err := r.isolateGoCache()
+	if want := "unable to make a temporary directory: mktemp: failed to create directory via template: No space left on device"; err == nil || err.Error() != want {
+		t.Errorf("isolateGoCache() = %v, want %q", err, want)
+	}
+	if r.goCacheDir != "" {
+		t.Errorf("goCacheDir is %q after failing", r.goCacheDir)
+	}
+}
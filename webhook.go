@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// defaultWebhookTemplate is the payload posted for each result when
+// -result-webhook-template isn't given.
+const defaultWebhookTemplate = `{"package": {{json .Package}}, "status": {{json .Status}}, ` +
+	`"duration_seconds": {{.Duration.Seconds}}, "worker": {{json .Worker}}}`
+
+// webhookAttempts is how many times a result is posted before giving up.
+const webhookAttempts = 3
+
+// resultWebhook posts each package's result to a URL, with a payload
+// rendered from a template that is given the Result.
+type resultWebhook struct {
+	url      string
+	template *template.Template
+	client   *http.Client
+	wg       sync.WaitGroup
+}
+
+// newResultWebhook returns a webhook that posts to url. tmpl is the payload
+// template, or if it starts with @, the name of a file holding it. Templates
+// can use the json function to quote values.
+func newResultWebhook(url, tmpl string) (*resultWebhook, error) {
+	if tmpl == "" {
+		tmpl = defaultWebhookTemplate
+	}
+	if strings.HasPrefix(tmpl, "@") {
+		data, err := os.ReadFile(tmpl[1:])
+		if err != nil {
+			return nil, err
+		}
+		tmpl = string(data)
+	}
+	t, err := template.New("webhook").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}).Parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	return &resultWebhook{
+		url:      url,
+		template: t,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// render returns the payload for result.
+func (h *resultWebhook) render(result Result) ([]byte, error) {
+	var payload bytes.Buffer
+	if err := h.template.Execute(&payload, result); err != nil {
+		return nil, err
+	}
+	return payload.Bytes(), nil
+}
+
+// Post sends result to the webhook in the background, retrying with a
+// growing delay if it fails.
+func (h *resultWebhook) Post(result Result) {
+	payload, err := h.render(result)
+	if err != nil {
+		log.Printf("unable to render webhook payload for %s: %s", result.Package, err)
+		return
+	}
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		for attempt := 1; ; attempt++ {
+			err := h.send(payload)
+			if err == nil {
+				return
+			}
+			if attempt == webhookAttempts {
+				log.Printf("unable to post result for %s: %s", result.Package, err)
+				return
+			}
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+	}()
+}
+
+func (h *resultWebhook) send(payload []byte) error {
+	resp, err := h.client.Post(h.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Wait waits for every result to be posted.
+func (h *resultWebhook) Wait() {
+	h.wg.Wait()
+}
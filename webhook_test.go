@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookRender(t *testing.T) {
+	result := Result{
+		Package:  "state",
+		Worker:   "homework1",
+		Passed:   false,
+		ExitCode: 1,
+		Duration: 1500 * time.Millisecond,
+	}
+	file := filepath.Join(t.TempDir(), "payload.tmpl")
+	if err := os.WriteFile(file, []byte(`{{.Package}}={{.Status}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"default", "", `{"package": "state", "status": "fail", "duration_seconds": 1.5, "worker": "homework1"}`},
+		{"custom", `{"text": {{json (printf "%s failed on %s with %d" .Package .Worker .ExitCode)}}}`,
+			`{"text": "state failed on homework1 with 1"}`},
+		{"quoting", `{"worker": {{json .Worker}}, "output": {{json "say \"hi\""}}}`,
+			`{"worker": "homework1", "output": "say \"hi\""}`},
+		{"file", "@" + file, "state=fail"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, err := newResultWebhook("http://localhost/hook", test.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			payload, err := h.render(result)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(payload) != test.want {
+				t.Errorf("render() = %s, want %s", payload, test.want)
+			}
+		})
+	}
+}
+
+func TestNewResultWebhookBadTemplate(t *testing.T) {
+	if _, err := newResultWebhook("http://localhost/hook", "{{.Package"); err == nil {
+		t.Errorf("a bad template was accepted")
+	}
+	if _, err := newResultWebhook("http://localhost/hook", "@"+filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("a missing template file was accepted")
+	}
+}
+
+func TestWebhookPostRetries(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		bodies = append(bodies, string(body))
+		// The first attempt fails, so the second succeeds.
+		if len(bodies) == 1 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	h, err := newResultWebhook(server.URL, `{{.Package}} {{.Status}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Post(Result{Package: "state", Passed: true})
+	h.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 2 || bodies[0] != "state pass" || bodies[1] != "state pass" {
+		t.Errorf("posted %q, want state pass twice", bodies)
+	}
+}
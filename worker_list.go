@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// writeWorkerList writes the workers a run would use to w, as a table of each
+// one's host with the user, port, dialer and tags it would be used with once
+// the inventory's settings have been applied over options.
+func writeWorkerList(w io.Writer, hosts []string, inv *inventory, options sshOptions) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tUSER\tPORT\tDIALER\tTAGS")
+	for _, host := range hosts {
+		entry, _ := inv.worker(host)
+		worker_options := entry.applyTo(options)
+		username, err := loginName(worker_options.User)
+		if err != nil {
+			return err
+		}
+		dialer := entry.Dialer
+		if dialer == "" {
+			dialer = "tcp"
+		}
+		tags := strings.Join(entry.Tags, ",")
+		if tags == "" {
+			tags = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", host, username, worker_options.port(), dialer, tags)
+	}
+	return tw.Flush()
+}
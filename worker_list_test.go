@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"os/user"
+	"strings"
+	"testing"
+)
+
+func TestWriteWorkerList(t *testing.T) {
+	defer func(f func() (*user.User, error)) { currentUser = f }(currentUser)
+	currentUser = func() (*user.User, error) { return &user.User{Username: "juju"}, nil }
+	inv, err := readInventory(strings.NewReader(`{"workers": [
+		{"host": "homework1", "user": "ci", "port": 2222, "tags": ["integration", "arm64"]},
+		{"host": "homework2", "dialer": "tcp"},
+		{"host": "homework3"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		hosts   []string
+		options sshOptions
+		want    string
+	}{{
+		name:  "inventory",
+		hosts: inv.hosts(),
+		want: "HOST       USER  PORT  DIALER  TAGS\n" +
+			"homework1  ci    2222  tcp     integration,arm64\n" +
+			"homework2  juju  22    tcp     -\n" +
+			"homework3  juju  22    tcp     -\n",
+	}, {
+		name:    "-o over the defaults",
+		hosts:   []string{"homework1", "homework9"},
+		options: sshOptions{User: "admin", Port: "2200"},
+		want: "HOST       USER   PORT  DIALER  TAGS\n" +
+			"homework1  ci     2222  tcp     integration,arm64\n" +
+			"homework9  admin  2200  tcp     -\n",
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeWorkerList(&buf, test.hosts, inv, test.options); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != test.want {
+				t.Errorf("writeWorkerList() wrote\n%s\nwant\n%s", buf.String(), test.want)
+			}
+		})
+	}
+}